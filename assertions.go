@@ -0,0 +1,233 @@
+package main
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// QueryResult is what the assertions get to look at: the number of rows and
+// the first column of the first row.
+type QueryResult struct {
+	Rows int
+	// Value is the text form of the first column of the first row. It is
+	// only meaningful when Rows > 0 and Null is false.
+	Value string
+	Null  bool
+	// Type is the name of the first column's data type.
+	Type string
+}
+
+// readQueryResult reads rows to the end and closes them.
+func readQueryResult(conn *pgx.Conn, rows pgx.Rows) (QueryResult, error) {
+	defer rows.Close()
+	var result QueryResult
+	if fields := rows.FieldDescriptions(); len(fields) > 0 {
+		result.Type = strconv.FormatUint(uint64(fields[0].DataTypeOID), 10)
+		if dataType, ok := conn.TypeMap().TypeForOID(fields[0].DataTypeOID); ok {
+			result.Type = dataType.Name
+		}
+	}
+	for rows.Next() {
+		result.Rows++
+		if result.Rows > 1 {
+			continue
+		}
+		values, err := rows.Values()
+		if err != nil {
+			return result, err
+		}
+		if len(values) > 0 {
+			result.Value, result.Null = formatValue(values[0])
+		}
+	}
+	rows.Close()
+	return result, rows.Err()
+}
+
+// formatValue renders a decoded column value the way psql would show it,
+// and reports whether it was NULL.
+func formatValue(value any) (string, bool) {
+	switch v := value.(type) {
+	case nil:
+		return "", true
+	case string:
+		return v, false
+	case []byte:
+		return string(v), false
+	case bool:
+		if v {
+			return "t", false
+		}
+		return "f", false
+	case time.Time:
+		return v.Format(time.RFC3339Nano), false
+	case driver.Valuer:
+		inner, err := v.Value()
+		if err != nil {
+			return fmt.Sprint(v), false
+		}
+		return formatValue(inner)
+	case fmt.Stringer:
+		return v.String(), false
+	default:
+		return fmt.Sprint(v), false
+	}
+}
+
+// Comparison is a numeric comparison such as "< 10" or ">= 1". A bare number
+// means "=".
+type Comparison struct {
+	Op    string
+	Value float64
+}
+
+var comparisonOps = []string{"<=", ">=", "!=", "==", "<", ">", "="}
+
+func ParseComparison(input string) (Comparison, error) {
+	s := strings.TrimSpace(input)
+	op := "="
+	for _, candidate := range comparisonOps {
+		if strings.HasPrefix(s, candidate) {
+			op = candidate
+			s = strings.TrimSpace(strings.TrimPrefix(s, candidate))
+			break
+		}
+	}
+	if op == "==" {
+		op = "="
+	}
+	value, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return Comparison{}, fmt.Errorf("invalid comparison `%s`: expected an operator (%s) and a number", input, strings.Join(comparisonOps, " "))
+	}
+	return Comparison{Op: op, Value: value}, nil
+}
+
+func (c Comparison) Holds(v float64) bool {
+	switch c.Op {
+	case "<":
+		return v < c.Value
+	case "<=":
+		return v <= c.Value
+	case ">":
+		return v > c.Value
+	case ">=":
+		return v >= c.Value
+	case "!=":
+		return v != c.Value
+	default:
+		return v == c.Value
+	}
+}
+
+func (c Comparison) String() string {
+	return c.Op + " " + strconv.FormatFloat(c.Value, 'f', -1, 64)
+}
+
+// Assertions are the conditions the --query result must meet for the ping
+// to pass. Unset ones are skipped; with none set at all the query has to
+// return at least one row.
+type Assertions struct {
+	Value   string
+	Compare *Comparison
+	Regex   *regexp.Regexp
+	Rows    *Comparison
+	Type    string
+}
+
+// AssertionSpec holds the unparsed --expect-* values.
+type AssertionSpec struct {
+	Value   string
+	Compare string
+	Regex   string
+	Rows    string
+	Type    string
+}
+
+// set sets the value of the --expect-<kind> flag.
+func (s *AssertionSpec) set(kind, value string) {
+	switch kind {
+	case "value":
+		s.Value = value
+	case "compare":
+		s.Compare = value
+	case "regex":
+		s.Regex = value
+	case "rows":
+		s.Rows = value
+	case "type":
+		s.Type = value
+	}
+}
+
+// NewAssertions builds the assertions from the --expect-* flag values.
+func NewAssertions(spec AssertionSpec) (Assertions, error) {
+	a := Assertions{Value: spec.Value, Type: spec.Type}
+	if spec.Compare != "" {
+		c, err := ParseComparison(spec.Compare)
+		if err != nil {
+			return a, err
+		}
+		a.Compare = &c
+	}
+	if spec.Regex != "" {
+		re, err := regexp.Compile(spec.Regex)
+		if err != nil {
+			return a, fmt.Errorf("invalid regex `%s`: %w", spec.Regex, err)
+		}
+		a.Regex = re
+	}
+	if spec.Rows != "" {
+		c, err := ParseComparison(spec.Rows)
+		if err != nil {
+			return a, err
+		}
+		a.Rows = &c
+	}
+	return a, nil
+}
+
+// Check returns why result doesn't meet the assertions, or "" if it does.
+func (a Assertions) Check(result QueryResult) string {
+	if a.Rows != nil {
+		if !a.Rows.Holds(float64(result.Rows)) {
+			return fmt.Sprintf("expected rows %s, got %d", a.Rows, result.Rows)
+		}
+	} else if result.Rows == 0 {
+		return "0 rows returned"
+	}
+	if a.Type != "" && result.Type != a.Type {
+		return fmt.Sprintf("expected column type %s, got %s", a.Type, result.Type)
+	}
+	if a.Value == "" && a.Compare == nil && a.Regex == nil {
+		return ""
+	}
+	if result.Rows == 0 {
+		return "expected a value, got 0 rows"
+	}
+	if result.Null {
+		return "expected a value, got NULL"
+	}
+	if a.Value != "" && result.Value != a.Value {
+		return fmt.Sprintf("expected value %q, got %q", a.Value, result.Value)
+	}
+	if a.Compare != nil {
+		v, err := strconv.ParseFloat(strings.TrimSpace(result.Value), 64)
+		if err != nil {
+			return fmt.Sprintf("expected a number %s, got %q", a.Compare, result.Value)
+		}
+		if !a.Compare.Holds(v) {
+			return fmt.Sprintf("expected value %s, got %s", a.Compare, result.Value)
+		}
+	}
+	if a.Regex != nil && !a.Regex.MatchString(result.Value) {
+		return fmt.Sprintf("expected value to match %s, got %q", a.Regex, result.Value)
+	}
+	return ""
+}
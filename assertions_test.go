@@ -0,0 +1,141 @@
+package main
+
+import (
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFormatValue(t *testing.T) {
+	tests := map[string]struct {
+		input        any
+		expected     string
+		expectedNull bool
+	}{
+		"nil":     {input: nil, expectedNull: true},
+		"string":  {input: "16.1", expected: "16.1"},
+		"int":     {input: int64(42), expected: "42"},
+		"bool":    {input: true, expected: "t"},
+		"numeric": {input: pgtype.Numeric{Int: big.NewInt(1234), Exp: -2, Valid: true}, expected: "12.34"},
+		"time":    {input: time.Date(2023, 3, 30, 15, 41, 14, 0, time.UTC), expected: "2023-03-30T15:41:14Z"},
+	}
+	for desc, tc := range tests {
+		value, null := formatValue(tc.input)
+		assert.Equal(t, tc.expected, value, desc)
+		assert.Equal(t, tc.expectedNull, null, desc)
+	}
+}
+
+func TestParseComparison(t *testing.T) {
+	tests := map[string]struct {
+		input    string
+		expected Comparison
+		err      bool
+	}{
+		"bare number":  {input: "3", expected: Comparison{Op: "=", Value: 3}},
+		"less than":    {input: "< 10", expected: Comparison{Op: "<", Value: 10}},
+		"at least":     {input: ">=1", expected: Comparison{Op: ">=", Value: 1}},
+		"double equal": {input: "== 0.5", expected: Comparison{Op: "=", Value: 0.5}},
+		"not equal":    {input: "!= 0", expected: Comparison{Op: "!=", Value: 0}},
+		"garbage":      {input: "about 10", err: true},
+	}
+	for desc, tc := range tests {
+		c, err := ParseComparison(tc.input)
+		if tc.err {
+			assert.Error(t, err, desc)
+			continue
+		}
+		assert.NoError(t, err, desc)
+		assert.Equal(t, tc.expected, c, desc)
+	}
+}
+
+func TestAssertionsCheck(t *testing.T) {
+	mustAssertions := func(spec AssertionSpec) Assertions {
+		a, err := NewAssertions(spec)
+		if err != nil {
+			t.Fatalf("error %v", err)
+		}
+		return a
+	}
+	tests := map[string]struct {
+		assertions Assertions
+		result     QueryResult
+		expected   string
+	}{
+		"default passes with a row": {
+			result: QueryResult{Rows: 1, Value: "1", Type: "int4"},
+		},
+		"default fails without rows": {
+			result:   QueryResult{Type: "int4"},
+			expected: "0 rows returned",
+		},
+		"row count": {
+			assertions: mustAssertions(AssertionSpec{Rows: "0"}),
+			result:     QueryResult{Type: "int4"},
+		},
+		"row count fails": {
+			assertions: mustAssertions(AssertionSpec{Rows: ">= 2"}),
+			result:     QueryResult{Rows: 1, Value: "1"},
+			expected:   "expected rows >= 2, got 1",
+		},
+		"value": {
+			assertions: mustAssertions(AssertionSpec{Value: "on"}),
+			result:     QueryResult{Rows: 1, Value: "off", Type: "text"},
+			expected:   `expected value "on", got "off"`,
+		},
+		"compare": {
+			assertions: mustAssertions(AssertionSpec{Compare: "< 10"}),
+			result:     QueryResult{Rows: 1, Value: "3", Type: "int8"},
+		},
+		"compare fails": {
+			assertions: mustAssertions(AssertionSpec{Compare: "< 10"}),
+			result:     QueryResult{Rows: 1, Value: "12", Type: "int8"},
+			expected:   "expected value < 10, got 12",
+		},
+		"compare not a number": {
+			assertions: mustAssertions(AssertionSpec{Compare: "< 10"}),
+			result:     QueryResult{Rows: 1, Value: "many", Type: "text"},
+			expected:   `expected a number < 10, got "many"`,
+		},
+		"regex": {
+			assertions: mustAssertions(AssertionSpec{Regex: `^16\.`}),
+			result:     QueryResult{Rows: 1, Value: "16.1", Type: "text"},
+		},
+		"regex fails": {
+			assertions: mustAssertions(AssertionSpec{Regex: `^16\.`}),
+			result:     QueryResult{Rows: 1, Value: "15.4", Type: "text"},
+			expected:   `expected value to match ^16\., got "15.4"`,
+		},
+		"null": {
+			assertions: mustAssertions(AssertionSpec{Regex: `.`}),
+			result:     QueryResult{Rows: 1, Null: true, Type: "text"},
+			expected:   "expected a value, got NULL",
+		},
+		"no rows for value": {
+			assertions: mustAssertions(AssertionSpec{Value: "1", Rows: ">= 0"}),
+			result:     QueryResult{Type: "text"},
+			expected:   "expected a value, got 0 rows",
+		},
+		"type": {
+			assertions: mustAssertions(AssertionSpec{Type: "int8"}),
+			result:     QueryResult{Rows: 1, Value: "1", Type: "int4"},
+			expected:   "expected column type int8, got int4",
+		},
+	}
+	for desc, tc := range tests {
+		assert.Equal(t, tc.expected, tc.assertions.Check(tc.result), desc)
+	}
+}
+
+func TestNewAssertionsErrors(t *testing.T) {
+	_, err := NewAssertions(AssertionSpec{Compare: "lots"})
+	assert.Error(t, err)
+	_, err = NewAssertions(AssertionSpec{Regex: "("})
+	assert.ErrorContains(t, err, "invalid regex")
+	_, err = NewAssertions(AssertionSpec{Rows: "some"})
+	assert.Error(t, err)
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config is what a --config file defines: named targets, each with its own
+// connection parameters and ping settings, and where results go.
+//
+//	targets:
+//	  primary:
+//	    host: db1.example.com
+//	    user: pgping
+//	    check: replication
+//	  reporting:
+//	    connstring: postgres://reporting.example.com/app
+//	    interval: 10s
+//	    query:
+//	      - SELECT count(*) FROM pg_stat_activity
+//	outputs:
+//	  - format: text
+//	  - format: json
+//	    path: /var/log/pgping.jsonl
+type Config struct {
+	Path    string
+	Targets []*TargetConfig
+	Outputs []OutputConfig
+}
+
+// TargetConfig is one target of a config file. Connection parameters that
+// are set beat the environment, service files and flags, and are themselves
+// beaten by ConnString. Ping settings that are set beat their flags.
+type TargetConfig struct {
+	Name string `yaml:"-"`
+
+	ConnString  string            `yaml:"connstring"`
+	Service     string            `yaml:"service"`
+	Host        string            `yaml:"host"`
+	Port        int               `yaml:"port"`
+	Database    string            `yaml:"database"`
+	User        string            `yaml:"user"`
+	Password    string            `yaml:"password"`
+	AppName     string            `yaml:"app_name"`
+	SSLMode     string            `yaml:"sslmode"`
+	SSLRootCert string            `yaml:"sslrootcert"`
+	SSLCert     string            `yaml:"sslcert"`
+	SSLKey      string            `yaml:"sslkey"`
+	SSLPassword string            `yaml:"sslpassword"`
+	SSLCRL      string            `yaml:"sslcrl"`
+	SSLSNI      string            `yaml:"sslsni"`
+	Params      map[string]string `yaml:"params"`
+
+	Interval       time.Duration `yaml:"interval"`
+	Timeout        time.Duration `yaml:"timeout"`
+	ConnectTimeout time.Duration `yaml:"connect_timeout"`
+	QueryTimeout   time.Duration `yaml:"query_timeout"`
+	CloseTimeout   time.Duration `yaml:"close_timeout"`
+	Query          stringList    `yaml:"query"`
+	QueryFile      string        `yaml:"query_file"`
+	Check          string        `yaml:"check"`
+
+	// node is where the target is defined, for error messages.
+	node *yaml.Node
+}
+
+// OutputConfig is somewhere results are written to. Without a path they go
+// to stdout.
+type OutputConfig struct {
+	Format string `yaml:"format"`
+	Path   string `yaml:"path"`
+}
+
+// stringList is a list of strings that may also be written as a single one.
+type stringList []string
+
+func (l *stringList) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		*l = stringList{value.Value}
+		return nil
+	}
+	var list []string
+	err := value.Decode(&list)
+	if err != nil {
+		return err
+	}
+	*l = list
+	return nil
+}
+
+// configLayout is the layout of a config file. Targets are decoded on their
+// own so that they keep the order they're defined in.
+type configLayout struct {
+	Targets map[string]*TargetConfig `yaml:"targets"`
+	Outputs []OutputConfig           `yaml:"outputs"`
+}
+
+// ReadConfig reads and validates the config file at path. Errors point at
+// the line they are about.
+func ReadConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var file configLayout
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	err = decoder.Decode(&file)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	var doc yaml.Node
+	err = yaml.Unmarshal(data, &doc)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	config := &Config{Path: path, Outputs: file.Outputs}
+	root := &doc
+	if root.Kind == yaml.DocumentNode && len(root.Content) > 0 {
+		root = root.Content[0]
+	}
+	targets := mappingValue(root, "targets")
+	if targets == nil || len(targets.Content) == 0 {
+		return nil, fmt.Errorf("%s: no targets defined", path)
+	}
+	for i := 0; i+1 < len(targets.Content); i += 2 {
+		name := targets.Content[i].Value
+		target := file.Targets[name]
+		if target == nil {
+			return nil, fmt.Errorf("%s:%d: target `%s` has no settings", path, targets.Content[i].Line, name)
+		}
+		target.Name = name
+		target.node = targets.Content[i+1]
+		config.Targets = append(config.Targets, target)
+	}
+	err = config.validate(mappingValue(root, "outputs"))
+	if err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+func (c *Config) validate(outputs *yaml.Node) error {
+	for _, target := range c.Targets {
+		switch target.Check {
+		case "", CheckPing, CheckReplication, CheckWrite:
+		default:
+			return c.errorf(mappingValue(target.node, "check"), "target `%s`: unknown check `%s` (ping, replication, write)", target.Name, target.Check)
+		}
+		if target.Interval < 0 {
+			return c.errorf(mappingValue(target.node, "interval"), "target `%s`: interval can't be negative", target.Name)
+		}
+		timeouts := []struct {
+			key     string
+			timeout time.Duration
+		}{
+			{"timeout", target.Timeout},
+			{"connect_timeout", target.ConnectTimeout},
+			{"query_timeout", target.QueryTimeout},
+			{"close_timeout", target.CloseTimeout},
+		}
+		for _, t := range timeouts {
+			if t.timeout < 0 {
+				return c.errorf(mappingValue(target.node, t.key), "target `%s`: %s can't be negative", target.Name, t.key)
+			}
+		}
+		if target.Port < 0 || target.Port > 65535 {
+			return c.errorf(mappingValue(target.node, "port"), "target `%s`: port %d is out of range", target.Name, target.Port)
+		}
+	}
+	for i, output := range c.Outputs {
+		switch output.Format {
+		case "", "text", "json":
+		default:
+			return c.errorf(outputs.Content[i], "output %d: unknown format `%s` (text, json)", i+1, output.Format)
+		}
+	}
+	return nil
+}
+
+func (c *Config) errorf(node *yaml.Node, format string, a ...any) error {
+	return fmt.Errorf("%s:%d: %s", c.Path, node.Line, fmt.Sprintf(format, a...))
+}
+
+// mappingValue returns the value of key in mapping, or nil if it has none.
+func mappingValue(mapping *yaml.Node, key string) *yaml.Node {
+	if mapping == nil || mapping.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			return mapping.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// apply overrides the flag defaults of pinger with the settings of config.
+func (config *TargetConfig) apply(pinger *Pinger, spec AssertionSpec) error {
+	if config.Interval != 0 {
+		pinger.Wait = config.Interval
+	}
+	if config.Timeout != 0 {
+		pinger.Timeout = config.Timeout
+	}
+	if config.ConnectTimeout != 0 {
+		pinger.Timeouts.Connect = config.ConnectTimeout
+	}
+	if config.QueryTimeout != 0 {
+		pinger.Timeouts.Query = config.QueryTimeout
+	}
+	if config.CloseTimeout != 0 {
+		pinger.Timeouts.Close = config.CloseTimeout
+	}
+	if config.Check != "" {
+		pinger.Check = config.Check
+	}
+	if len(config.Query) > 0 || config.QueryFile != "" {
+		queries, err := buildQueries(config.Query, config.QueryFile, spec)
+		if err != nil {
+			return fmt.Errorf("target `%s`: %w", config.Name, err)
+		}
+		pinger.Queries = queries
+	}
+	return nil
+}
+
+// openOutputs opens the outputs of a config file.
+func openOutputs(configs []OutputConfig) ([]Output, error) {
+	outputs := make([]Output, 0, len(configs))
+	for _, config := range configs {
+		output := Output{Format: config.Format, Writer: os.Stdout}
+		if output.Format == "" {
+			output.Format = "text"
+		}
+		if config.Path != "" && config.Path != "-" {
+			f, err := os.OpenFile(config.Path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+			if err != nil {
+				return nil, err
+			}
+			output.Writer = f
+		}
+		outputs = append(outputs, output)
+	}
+	return outputs, nil
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func writeConfig(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "pgping.yaml")
+	err := os.WriteFile(path, []byte(content), 0o600)
+	if err != nil {
+		t.Fatalf("error writing config %v", err)
+	}
+	return path
+}
+
+func TestReadConfig(t *testing.T) {
+	path := writeConfig(t, `targets:
+  reporting:
+    connstring: postgres://reporting.example.com/app
+    interval: 10s
+    query: SELECT 1
+  primary:
+    host: db1.example.com
+    port: 5433
+    sslmode: verify-full
+    sslrootcert: /etc/ssl/ca.pem
+    params:
+      connect_timeout: "3"
+    timeout: 2s
+    query_timeout: 500ms
+    check: replication
+    query:
+      - SELECT 1
+      - SELECT 2
+outputs:
+  - format: json
+    path: /var/log/pgping.jsonl
+`)
+	config, err := ReadConfig(path)
+	if err != nil {
+		t.Fatalf("error %v", err)
+	}
+	if assert.Len(t, config.Targets, 2) {
+		reporting, primary := config.Targets[0], config.Targets[1]
+		assert.Equal(t, "reporting", reporting.Name)
+		assert.Equal(t, "postgres://reporting.example.com/app", reporting.ConnString)
+		assert.Equal(t, 10*time.Second, reporting.Interval)
+		assert.Equal(t, stringList{"SELECT 1"}, reporting.Query)
+
+		assert.Equal(t, "primary", primary.Name)
+		assert.Equal(t, "db1.example.com", primary.Host)
+		assert.Equal(t, 5433, primary.Port)
+		assert.Equal(t, "/etc/ssl/ca.pem", primary.SSLRootCert)
+		assert.Equal(t, map[string]string{"connect_timeout": "3"}, primary.Params)
+		assert.Equal(t, 2*time.Second, primary.Timeout)
+		assert.Equal(t, 500*time.Millisecond, primary.QueryTimeout)
+		assert.Equal(t, CheckReplication, primary.Check)
+		assert.Equal(t, stringList{"SELECT 1", "SELECT 2"}, primary.Query)
+	}
+	assert.Equal(t, []OutputConfig{{Format: "json", Path: "/var/log/pgping.jsonl"}}, config.Outputs)
+}
+
+func TestReadConfigErrors(t *testing.T) {
+	tests := map[string]struct {
+		content string
+		expect  string
+	}{
+		"unknown field": {
+			content: "targets:\n  a:\n    host: x\n    hostname: y\n",
+			expect:  "line 4: field hostname not found",
+		},
+		"bad duration": {
+			content: "targets:\n  a:\n    interval: soon\n",
+			expect:  "line 3: cannot unmarshal !!str `soon` into time.Duration",
+		},
+		"unknown check": {
+			content: "targets:\n  a:\n    host: x\n    check: vibes\n",
+			expect:  ":4: target `a`: unknown check `vibes`",
+		},
+		"visibility check": {
+			content: "targets:\n  a:\n    check: visibility\n",
+			expect:  ":3: target `a`: unknown check `visibility`",
+		},
+		"negative query timeout": {
+			content: "targets:\n  a:\n    host: x\n    query_timeout: -1s\n",
+			expect:  ":4: target `a`: query_timeout can't be negative",
+		},
+		"port out of range": {
+			content: "targets:\n  a:\n    port: 70000\n",
+			expect:  ":3: target `a`: port 70000 is out of range",
+		},
+		"unknown output format": {
+			content: "targets:\n  a:\n    host: x\noutputs:\n  - format: text\n  - format: xml\n",
+			expect:  ":6: output 2: unknown format `xml`",
+		},
+		"no targets": {
+			content: "outputs:\n  - format: json\n",
+			expect:  "no targets defined",
+		},
+		"empty target": {
+			content: "targets:\n  a:\n",
+			expect:  ":2: target `a` has no settings",
+		},
+	}
+	for desc, tc := range tests {
+		_, err := ReadConfig(writeConfig(t, tc.content))
+		assert.ErrorContains(t, err, tc.expect, desc)
+	}
+}
+
+func TestTargetFromConfig(t *testing.T) {
+	target := &Target{Host: "flags.example.com", Port: 5432, User: "flags", Params: map[string]string{"options": "-c x=1"}}
+	err := target.FromConfig(&TargetConfig{
+		Host:   "config.example.com",
+		SSLKey: "/etc/ssl/client.key",
+		Params: map[string]string{"connect_timeout": "3"},
+	})
+	if err != nil {
+		t.Fatalf("error %v", err)
+	}
+	assert.Equal(t, &Target{
+		Host:   "config.example.com",
+		Port:   5432,
+		User:   "flags",
+		SSLKey: "/etc/ssl/client.key",
+		Params: map[string]string{"options": "-c x=1", "connect_timeout": "3"},
+	}, target)
+}
+
+func TestTargetConfigApply(t *testing.T) {
+	pinger := &Pinger{Wait: time.Second, Timeout: 5 * time.Second, Check: CheckPing, Queries: []Query{{Name: "query1", SQL: "SELECT 1"}}}
+	config := &TargetConfig{Name: "a", Interval: 10 * time.Second, QueryTimeout: time.Second, Check: CheckWrite, Query: stringList{"SELECT 2"}}
+	err := config.apply(pinger, AssertionSpec{})
+	if err != nil {
+		t.Fatalf("error %v", err)
+	}
+	assert.Equal(t, 10*time.Second, pinger.Wait)
+	assert.Equal(t, 5*time.Second, pinger.Timeout)
+	assert.Equal(t, Timeouts{Query: time.Second}, pinger.Timeouts)
+	assert.Equal(t, CheckWrite, pinger.Check)
+	assert.Equal(t, []Query{{Name: "query1", SQL: "SELECT 2"}}, pinger.Queries)
+
+	err = (&TargetConfig{Name: "b", QueryFile: "/nonexistent/queries.sql"}).apply(pinger, AssertionSpec{})
+	assert.ErrorContains(t, err, "target `b`")
+}
@@ -0,0 +1,346 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// histogramGrowth is how much wider each bucket of a Histogram is than the
+// one before it.
+const histogramGrowth = 1.01
+
+// Histogram records durations in buckets 1% wide starting at a microsecond,
+// which keeps its percentiles within 1% however many samples it holds.
+type Histogram struct {
+	Count int
+	Max   time.Duration
+
+	buckets map[int]int
+}
+
+func histogramBucket(d time.Duration) int {
+	if d <= time.Microsecond {
+		return 0
+	}
+	return int(math.Ceil(math.Log(float64(d)/float64(time.Microsecond)) / math.Log(histogramGrowth)))
+}
+
+// histogramBucketMax is the largest duration that falls into bucket b.
+func histogramBucketMax(b int) time.Duration {
+	return time.Duration(float64(time.Microsecond) * math.Pow(histogramGrowth, float64(b)))
+}
+
+func (h *Histogram) Add(d time.Duration) {
+	if h.buckets == nil {
+		h.buckets = make(map[int]int)
+	}
+	h.buckets[histogramBucket(d)]++
+	h.Count++
+	if d > h.Max {
+		h.Max = d
+	}
+}
+
+// Percentile returns the duration p percent of the samples are at or below.
+func (h *Histogram) Percentile(p float64) time.Duration {
+	if h.Count == 0 {
+		return 0
+	}
+	buckets := make([]int, 0, len(h.buckets))
+	for b := range h.buckets {
+		buckets = append(buckets, b)
+	}
+	sort.Ints(buckets)
+	rank := int(math.Ceil(p / 100 * float64(h.Count)))
+	seen := 0
+	for _, b := range buckets {
+		seen += h.buckets[b]
+		if seen >= rank {
+			return min(histogramBucketMax(b), h.Max)
+		}
+	}
+	return h.Max
+}
+
+// floodPercentiles are the latency percentiles flood reports show.
+var floodPercentiles = []float64{50, 90, 99}
+
+// FloodReport sums up the pings of a flood over a reporting interval or, for
+// the final report, the whole flood.
+type FloodReport struct {
+	Time    time.Time
+	Target  string
+	Final   bool
+	Elapsed time.Duration
+	Pings   int
+	OK      int
+	// Errors counts the failed pings by error class, or by status for those
+	// that failed without an error.
+	Errors  map[string]int
+	Latency Histogram
+}
+
+func (r *FloodReport) Add(res PingResult) {
+	r.Pings++
+	if !res.Pass() {
+		if r.Errors == nil {
+			r.Errors = make(map[string]int)
+		}
+		class := res.ErrorClass()
+		if class == "" {
+			class = strings.ToLower(res.Status)
+		}
+		r.Errors[class]++
+		return
+	}
+	r.OK++
+	r.Latency.Add(res.Duration)
+}
+
+// Rate is the number of pings per second.
+func (r *FloodReport) Rate() float64 {
+	if r.Elapsed <= 0 {
+		return 0
+	}
+	return float64(r.Pings) / r.Elapsed.Seconds()
+}
+
+func (r *FloodReport) errorClasses() []string {
+	classes := make([]string, 0, len(r.Errors))
+	for class := range r.Errors {
+		classes = append(classes, class)
+	}
+	sort.Strings(classes)
+	return classes
+}
+
+func (r *FloodReport) event() string {
+	if r.Final {
+		return "flood_final"
+	}
+	return "flood_report"
+}
+
+func (r *FloodReport) Text() string {
+	kvs := make([]string, 0)
+	if r.Target != "" {
+		kvs = append(kvs, kv("target", r.Target))
+	}
+	kvs = append(kvs, kv("event", r.event()))
+	kvs = append(kvs, kv("elapsed", r.Elapsed.Round(time.Millisecond)))
+	kvs = append(kvs, kv("pings", r.Pings))
+	kvs = append(kvs, kv("ok", r.OK))
+	kvs = append(kvs, kv("errors", r.Pings-r.OK))
+	kvs = append(kvs, kv("rate", fmt.Sprintf("%.1f/s", r.Rate())))
+	for _, p := range floodPercentiles {
+		kvs = append(kvs, kv(fmt.Sprintf("p%g", p), r.Latency.Percentile(p)))
+	}
+	kvs = append(kvs, kv("max", r.Latency.Max))
+	for _, class := range r.errorClasses() {
+		kvs = append(kvs, kv("errors."+class, r.Errors[class]))
+	}
+	var format strings.Builder
+	format.WriteString(fmt.Sprintf("%-25s", r.Time.Format(timestampFormat)))
+	format.WriteString(strings.Join(kvs, " "))
+	return format.String()
+}
+
+type jsonFloodReport struct {
+	Type         string           `json:"type"`
+	Timestamp    time.Time        `json:"timestamp"`
+	Target       string           `json:"target,omitempty"`
+	ElapsedNs    int64            `json:"elapsed_ns"`
+	Pings        int              `json:"pings"`
+	OK           int              `json:"ok"`
+	Errors       map[string]int   `json:"errors"`
+	RatePerSec   float64          `json:"rate_per_sec"`
+	LatencyNs    map[string]int64 `json:"latency_ns"`
+	MaxLatencyNs int64            `json:"max_latency_ns"`
+}
+
+func (r *FloodReport) JSON() ([]byte, error) {
+	out := jsonFloodReport{
+		Type:         r.event(),
+		Timestamp:    r.Time.UTC(),
+		Target:       r.Target,
+		ElapsedNs:    r.Elapsed.Nanoseconds(),
+		Pings:        r.Pings,
+		OK:           r.OK,
+		Errors:       r.Errors,
+		RatePerSec:   r.Rate(),
+		LatencyNs:    make(map[string]int64, len(floodPercentiles)),
+		MaxLatencyNs: r.Latency.Max.Nanoseconds(),
+	}
+	if out.Errors == nil {
+		out.Errors = map[string]int{}
+	}
+	for _, p := range floodPercentiles {
+		out.LatencyNs[fmt.Sprintf("p%g", p)] = r.Latency.Percentile(p).Nanoseconds()
+	}
+	return json.Marshal(out)
+}
+
+func printFloodReport(r *FloodReport) {
+	logOutputs(func(format string) []string {
+		if format == "json" {
+			line, err := r.JSON()
+			if err != nil {
+				logger.Error("error encoding flood report", "err", err)
+				return nil
+			}
+			return []string{string(line)}
+		}
+		return []string{r.Text()}
+	})
+}
+
+// Flood runs the connect and query cycle of a ping against one target from
+// many goroutines at once, as fast as they go or at a set rate, to find out
+// how many new connections the server or its pooler can take, or through the
+// pinger's pool to find out how it holds up under load. Instead of a
+// line per ping it reports throughput, latency percentiles and errors every
+// ReportInterval and once more at the end.
+type Flood struct {
+	Pinger      *Pinger
+	Concurrency int
+	// Rate caps the pings per second across every goroutine. Zero means
+	// no cap.
+	Rate float64
+	// Duration stops the flood after this long. Zero means run until count
+	// is reached or the flood is interrupted.
+	Duration       time.Duration
+	ReportInterval time.Duration
+}
+
+func NewFlood(pinger *Pinger) *Flood {
+	pinger.Quiet = true
+	return &Flood{
+		Pinger:         pinger,
+		Concurrency:    *floodConcurrency,
+		Rate:           *floodRate,
+		Duration:       *floodDuration,
+		ReportInterval: *floodReportInterval,
+	}
+}
+
+// Run floods until count pings have been sent, Duration is up or ctx is
+// cancelled and reports whether any ping failed.
+func (f *Flood) Run(ctx context.Context) bool {
+	if f.Duration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, f.Duration)
+		defer cancel()
+	}
+
+	var tokens chan struct{}
+	if f.Rate > 0 {
+		tokens = make(chan struct{}, f.Concurrency)
+		go f.pace(ctx, tokens)
+	}
+
+	var (
+		next    atomic.Int64
+		results = make(chan PingResult, f.Concurrency)
+		workers sync.WaitGroup
+	)
+	for range f.Concurrency {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			// each worker gets its own copy, as a Pinger isn't safe for
+			// concurrent use. The copies share the pool, if any, which is;
+			// otherwise every ping opens a new connection.
+			pinger := *f.Pinger
+			pinger.Persistent = false
+			for {
+				if tokens != nil {
+					select {
+					case <-ctx.Done():
+						return
+					case <-tokens:
+					}
+				}
+				i := int(next.Add(1))
+				if (*count != -1 && i > *count) || floodOver(ctx) {
+					return
+				}
+				res := pinger.Ping(ctx, i)
+				if floodOver(ctx) {
+					// interrupted mid-ping, so this one doesn't count
+					return
+				}
+				results <- res
+			}
+		}()
+	}
+	go func() {
+		workers.Wait()
+		close(results)
+	}()
+
+	start := time.Now()
+	interval := &FloodReport{Target: f.Pinger.Label}
+	total := &FloodReport{Target: f.Pinger.Label, Final: true}
+	intervalStart := start
+	ticker := time.NewTicker(f.ReportInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case res, ok := <-results:
+			if !ok {
+				total.Time = time.Now()
+				total.Elapsed = total.Time.Sub(start)
+				printFloodReport(total)
+				f.Pinger.closeHeld()
+				return total.OK < total.Pings
+			}
+			interval.Add(res)
+			total.Add(res)
+			f.Pinger.Stats.Add(res)
+			f.Pinger.Metrics.Observe(f.Pinger.Name(), res)
+		case now := <-ticker.C:
+			interval.Time = now
+			interval.Elapsed = now.Sub(intervalStart)
+			printFloodReport(interval)
+			interval = &FloodReport{Target: f.Pinger.Label}
+			intervalStart = now
+		}
+	}
+}
+
+// floodOver reports whether ctx is done. It goes by the deadline as well as
+// ctx.Err, as dials notice a deadline has passed before ctx does and would
+// otherwise count as timeouts.
+func floodOver(ctx context.Context) bool {
+	deadline, ok := ctx.Deadline()
+	return ctx.Err() != nil || (ok && !time.Now().Before(deadline))
+}
+
+// pace hands out Rate tokens a second until ctx is done. Tokens beyond one
+// per goroutine are dropped, so a flood that can't keep up with Rate shows up
+// as a lower rate in the reports rather than a burst later on.
+func (f *Flood) pace(ctx context.Context, tokens chan<- struct{}) {
+	// rates above 1e9/s would round the interval down to 0, which tickers
+	// don't take; nothing pings that fast anyway
+	interval := max(time.Duration(float64(time.Second)/f.Rate), time.Nanosecond)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			select {
+			case tokens <- struct{}{}:
+			default:
+			}
+		}
+	}
+}
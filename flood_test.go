@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHistogramPercentile(t *testing.T) {
+	var h Histogram
+	assert.Equal(t, time.Duration(0), h.Percentile(50))
+	for i := 1; i <= 1000; i++ {
+		h.Add(time.Duration(i) * time.Millisecond)
+	}
+	tests := map[string]struct {
+		p      float64
+		expect time.Duration
+	}{
+		"p50":  {p: 50, expect: 500 * time.Millisecond},
+		"p90":  {p: 90, expect: 900 * time.Millisecond},
+		"p99":  {p: 99, expect: 990 * time.Millisecond},
+		"p100": {p: 100, expect: time.Second},
+	}
+	for desc, tc := range tests {
+		got := h.Percentile(tc.p)
+		assert.InEpsilon(t, float64(tc.expect), float64(got), 0.01, desc)
+	}
+	assert.Equal(t, 1000, h.Count)
+	assert.Equal(t, time.Second, h.Max)
+}
+
+func TestHistogramPercentileNeverAboveMax(t *testing.T) {
+	var h Histogram
+	h.Add(1234 * time.Microsecond)
+	assert.Equal(t, 1234*time.Microsecond, h.Percentile(99))
+}
+
+func TestFloodReport(t *testing.T) {
+	r := &FloodReport{
+		Time:    time.Date(2023, 3, 30, 15, 41, 14, 0, time.UTC),
+		Target:  "db.example.com",
+		Elapsed: 2 * time.Second,
+	}
+	r.Add(PingResult{Status: StatusOK, Duration: 10 * time.Millisecond})
+	r.Add(PingResult{Status: StatusOK, Duration: 20 * time.Millisecond})
+	r.Add(PingResult{Status: StatusErr, Err: fmt.Errorf("dial error: %w", syscall.ECONNREFUSED)})
+	r.Add(PingResult{Status: StatusFail, Msg: "unexpected value"})
+
+	assert.Equal(t, 4, r.Pings)
+	assert.Equal(t, 2, r.OK)
+	assert.Equal(t, map[string]int{"connection_refused": 1, "fail": 1}, r.Errors)
+	assert.Equal(t, 2.0, r.Rate())
+
+	text := r.Text()
+	assert.Contains(t, text, `target="db.example.com" event="flood_report" elapsed=2s pings=4 ok=2 errors=2 rate="2.0/s"`)
+	assert.Contains(t, text, "errors.connection_refused=1 errors.fail=1")
+
+	r.Final = true
+	line, err := r.JSON()
+	if err != nil {
+		t.Fatalf("error %v", err)
+	}
+	var out map[string]any
+	err = json.Unmarshal(line, &out)
+	if err != nil {
+		t.Fatalf("error %v", err)
+	}
+	assert.Equal(t, "flood_final", out["type"])
+	assert.Equal(t, float64(4), out["pings"])
+	assert.Equal(t, map[string]any{"connection_refused": float64(1), "fail": float64(1)}, out["errors"])
+	assert.Equal(t, float64(20*time.Millisecond), out["max_latency_ns"])
+	assert.Contains(t, out["latency_ns"], "p99")
+}
+
+func TestFloodReportEmpty(t *testing.T) {
+	r := &FloodReport{}
+	assert.Equal(t, 0.0, r.Rate())
+	line, err := r.JSON()
+	if err != nil {
+		t.Fatalf("error %v", err)
+	}
+	assert.Contains(t, string(line), `"errors":{}`)
+}
+
+func TestFloodPaceAboveOneBillion(t *testing.T) {
+	f := &Flood{Rate: 5e9}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	tokens := make(chan struct{}, 1)
+	go f.pace(ctx, tokens)
+	select {
+	case <-tokens:
+	case <-time.After(time.Second):
+		t.Fatal("no token paced")
+	}
+}
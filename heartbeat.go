@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+const CheckWrite = "write"
+
+// Heartbeat is the row --check write upserts on every ping. Each client gets
+// its own row, which records the ping that last wrote it and when, so that
+// it can be correlated with pgping's output afterwards.
+type Heartbeat struct {
+	// Table may be schema qualified.
+	Table  string
+	Client string
+	// Create creates Table if it doesn't exist yet.
+	Create bool
+}
+
+// defaultHeartbeatClient keys the heartbeats of a pinger that writes them by
+// the hostname unless --heartbeat-client says otherwise. It has to run once
+// the target's config is applied, since that can turn --check write on.
+func (p *Pinger) defaultHeartbeatClient() error {
+	if p.Heartbeat.Client != "" || (p.Check != CheckWrite && p.Check != CheckVisibility) {
+		return nil
+	}
+	hostname, err := os.Hostname()
+	if err != nil {
+		return err
+	}
+	p.Heartbeat.Client = hostname
+	return nil
+}
+
+func (h Heartbeat) table() string {
+	return pgx.Identifier(strings.Split(h.Table, ".")).Sanitize()
+}
+
+func (h Heartbeat) createQuery() string {
+	return fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+	client text PRIMARY KEY,
+	iteration bigint NOT NULL,
+	client_time timestamptz NOT NULL,
+	server_time timestamptz NOT NULL DEFAULT now()
+)`, h.table())
+}
+
+func (h Heartbeat) upsertQuery() string {
+	return fmt.Sprintf(`INSERT INTO %s (client, iteration, client_time, server_time)
+VALUES ($1, $2, $3, now())
+ON CONFLICT (client) DO UPDATE SET
+	iteration = excluded.iteration,
+	client_time = excluded.client_time,
+	server_time = excluded.server_time`, h.table())
+}
+
+func (h Heartbeat) readQuery() string {
+	return fmt.Sprintf("SELECT iteration, client_time FROM %s WHERE client = $1", h.table())
+}
+
+// heartbeatWrite is a heartbeat that was written. Together the iteration and
+// client time tag it uniquely.
+type heartbeatWrite struct {
+	Iteration  int64
+	ClientTime time.Time
+	// Committed is when the commit returned.
+	Committed time.Time
+}
+
+// write upserts the heartbeat for ping i in a transaction of its own, then
+// reads it back. The upsert, its commit (which is where synchronous
+// replication waits) and the read are timed as separate phases. committed,
+// if set, is called as soon as the commit returns. A heartbeat that reads
+// back different from what was written is returned as a failure message
+// rather than an error.
+func (h Heartbeat) write(ctx context.Context, conn *pgx.Conn, timings *Timings, i int, committed func(heartbeatWrite)) (heartbeatWrite, string, error) {
+	// timestamptz only keeps microseconds
+	written := heartbeatWrite{Iteration: int64(i), ClientTime: time.Now().Truncate(time.Microsecond)}
+	writeCtx := withPhase(ctx, timings, PhaseWrite)
+	tx, err := conn.Begin(writeCtx)
+	if err != nil {
+		return written, "", err
+	}
+	defer tx.Rollback(ctx)
+	_, err = tx.Exec(writeCtx, h.upsertQuery(), h.Client, written.Iteration, written.ClientTime)
+	if err != nil {
+		return written, "", err
+	}
+	err = tx.Commit(withPhase(ctx, timings, PhaseCommit))
+	if err != nil {
+		return written, "", err
+	}
+	written.Committed = time.Now()
+	if committed != nil {
+		committed(written)
+	}
+
+	var read heartbeatWrite
+	err = conn.QueryRow(withPhase(ctx, timings, PhaseRead), h.readQuery(), h.Client).Scan(&read.Iteration, &read.ClientTime)
+	if err != nil {
+		return written, "", err
+	}
+	if !read.matches(written) {
+		return written, fmt.Sprintf(
+			"heartbeat read back as i=%d client_time=%s, wrote i=%d client_time=%s",
+			read.Iteration,
+			read.ClientTime.UTC().Format(time.RFC3339Nano),
+			written.Iteration,
+			written.ClientTime.UTC().Format(time.RFC3339Nano),
+		), nil
+	}
+	return written, "", nil
+}
+
+func (w heartbeatWrite) matches(other heartbeatWrite) bool {
+	return w.Iteration == other.Iteration && w.ClientTime.Equal(other.ClientTime)
+}
+
+// visible reports whether the heartbeat on conn is the written one yet.
+func (h Heartbeat) visible(ctx context.Context, conn *pgx.Conn, written heartbeatWrite) (bool, error) {
+	var read heartbeatWrite
+	err := conn.QueryRow(ctx, h.readQuery(), h.Client).Scan(&read.Iteration, &read.ClientTime)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return read.matches(written), nil
+}
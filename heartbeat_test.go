@@ -0,0 +1,86 @@
+package main
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHeartbeatTable(t *testing.T) {
+	tests := map[string]struct {
+		table  string
+		expect string
+	}{
+		"plain": {
+			table:  "pgping_heartbeat",
+			expect: `"pgping_heartbeat"`,
+		},
+		"schema qualified": {
+			table:  "monitoring.heartbeat",
+			expect: `"monitoring"."heartbeat"`,
+		},
+		"quoted": {
+			table:  `odd"name`,
+			expect: `"odd""name"`,
+		},
+	}
+	for desc, tc := range tests {
+		assert.Equal(t, tc.expect, Heartbeat{Table: tc.table}.table(), desc)
+	}
+}
+
+func TestHeartbeatQueries(t *testing.T) {
+	h := Heartbeat{Table: "monitoring.heartbeat", Client: "app1"}
+	assert.Contains(t, h.createQuery(), `CREATE TABLE IF NOT EXISTS "monitoring"."heartbeat"`)
+	assert.Contains(t, h.upsertQuery(), `INSERT INTO "monitoring"."heartbeat" (client, iteration, client_time, server_time)`)
+	assert.Contains(t, h.upsertQuery(), "ON CONFLICT (client) DO UPDATE")
+	assert.Equal(t, `SELECT iteration, client_time FROM "monitoring"."heartbeat" WHERE client = $1`, h.readQuery())
+}
+
+func TestHeartbeatWriteMatches(t *testing.T) {
+	clientTime := time.Date(2023, 3, 30, 15, 41, 14, 123456000, time.UTC)
+	written := heartbeatWrite{Iteration: 3, ClientTime: clientTime, Committed: clientTime.Add(time.Millisecond)}
+	tests := map[string]struct {
+		read   heartbeatWrite
+		expect bool
+	}{
+		"same": {
+			read:   heartbeatWrite{Iteration: 3, ClientTime: clientTime.In(time.FixedZone("CEST", 2*60*60))},
+			expect: true,
+		},
+		"earlier ping": {
+			read: heartbeatWrite{Iteration: 2, ClientTime: clientTime.Add(-time.Second)},
+		},
+		"earlier run": {
+			read: heartbeatWrite{Iteration: 3, ClientTime: clientTime.Add(-time.Hour)},
+		},
+	}
+	for desc, tc := range tests {
+		assert.Equal(t, tc.expect, tc.read.matches(written), desc)
+	}
+}
+
+func TestDefaultHeartbeatClient(t *testing.T) {
+	hostname, err := os.Hostname()
+	if err != nil {
+		t.Fatalf("error %v", err)
+	}
+	tests := map[string]struct {
+		check    string
+		client   string
+		expected string
+	}{
+		"write":             {check: CheckWrite, expected: hostname},
+		"visibility":        {check: CheckVisibility, expected: hostname},
+		"write with client": {check: CheckWrite, client: "app1", expected: "app1"},
+		"ping":              {check: CheckPing, expected: ""},
+	}
+	for desc, tc := range tests {
+		p := &Pinger{Check: tc.check, Heartbeat: Heartbeat{Client: tc.client}}
+		err := p.defaultHeartbeatClient()
+		assert.NoError(t, err, desc)
+		assert.Equal(t, tc.expected, p.Heartbeat.Client, desc)
+	}
+}
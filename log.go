@@ -1,50 +1,128 @@
 package main
 
 import (
+	"context"
 	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"os"
+	"strings"
+	"sync"
 )
 
-type LogLevel string
-
 const (
-	LogLevelDefault LogLevel = "default"
-	LogLevelSilent  LogLevel = "silent"
-	LogLevelDebug   LogLevel = "debug"
+	LevelTrace = slog.Level(-8)
+	// LevelSilent is above every level anything is ever logged at, so setting
+	// it turns off diagnostics as well as results.
+	LevelSilent = slog.Level(math.MaxInt32)
 )
 
-func LogLevelFromString(s string) LogLevel {
-	switch s {
+var levelNames = map[slog.Level]string{
+	LevelTrace:  "TRACE",
+	LevelSilent: "SILENT",
+}
+
+func LogLevelFromString(s string) (slog.Level, error) {
+	switch strings.ToLower(s) {
+	case "silent":
+		return LevelSilent, nil
+	case "error":
+		return slog.LevelError, nil
+	case "", "default", "info":
+		return slog.LevelInfo, nil
 	case "debug":
-		return LogLevelDebug
+		return slog.LevelDebug, nil
+	case "trace":
+		return LevelTrace, nil
 	default:
-		return LogLevelDefault
+		return slog.LevelInfo, fmt.Errorf("unknown log level `%s`", s)
 	}
 }
 
-func logln(a ...any) {
-	if LogLevelFromString(*logLevel) != LogLevelSilent {
-		fmt.Println(a...)
+var (
+	// logger writes diagnostics to stderr. It starts out at the default level
+	// so that anything logged before the flags are parsed isn't lost.
+	logger = newLogger(os.Stderr, slog.LevelInfo, "text")
+
+	// results are written to stdout, separately from diagnostics.
+	results   io.Writer = os.Stdout
+	resultsMu sync.Mutex
+	silent    bool
+)
+
+func newLogger(w io.Writer, level slog.Level, format string) *slog.Logger {
+	opts := &slog.HandlerOptions{
+		Level: level,
+		ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+			if a.Key != slog.LevelKey || len(groups) != 0 {
+				return a
+			}
+			if name, ok := levelNames[a.Value.Any().(slog.Level)]; ok {
+				a.Value = slog.StringValue(name)
+			}
+			return a
+		},
+	}
+	if format == "json" {
+		return slog.New(slog.NewJSONHandler(w, opts))
 	}
+	return slog.New(slog.NewTextHandler(w, opts))
 }
 
-func logf(format string, a ...any) {
-	if LogLevelFromString(*logLevel) != LogLevelSilent {
-		fmt.Printf(format, a...)
-		fmt.Println()
+// setupLogging configures the logger from the parsed flags.
+func setupLogging() error {
+	level, err := LogLevelFromString(*logLevel)
+	if err != nil {
+		return err
 	}
+	logger = newLogger(os.Stderr, level, *logFormat)
+	silent = level == LevelSilent
+	return nil
 }
 
-func debugln(a ...any) {
-	if LogLevelFromString(*logLevel) == LogLevelDebug {
-		fmt.Print("DEBUG: ")
-		fmt.Println(a...)
+func trace(msg string, args ...any) {
+	logger.Log(context.Background(), LevelTrace, msg, args...)
+}
+
+// logln writes a line of results to stdout unless running silent.
+func logln(a ...any) {
+	if silent {
+		return
 	}
+	resultsMu.Lock()
+	defer resultsMu.Unlock()
+	fmt.Fprintln(results, a...)
+}
+
+// Output is somewhere results are written to, and the format they're
+// written in there.
+type Output struct {
+	Format string
+	Writer io.Writer
 }
 
-func debugf(format string, a ...any) {
-	if LogLevelFromString(*logLevel) == LogLevelDebug {
-		fmt.Print("DEBUG: ")
-		fmt.Printf(format, a...)
-		fmt.Println()
+// outputs are where results go. With none configured they go to stdout in
+// the --output format.
+var outputs []Output
+
+// logOutputs writes the lines render returns for the format of each output
+// to it, unless running silent.
+func logOutputs(render func(format string) []string) {
+	if silent {
+		return
+	}
+	if len(outputs) == 0 {
+		for _, line := range render(*outputFormat) {
+			logln(line)
+		}
+		return
+	}
+	resultsMu.Lock()
+	defer resultsMu.Unlock()
+	for _, output := range outputs {
+		for _, line := range render(output.Format) {
+			fmt.Fprintln(output.Writer, line)
+		}
 	}
 }
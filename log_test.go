@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLogLevelFromString(t *testing.T) {
+	tests := map[string]struct {
+		input  string
+		expect slog.Level
+		err    bool
+	}{
+		"silent":  {input: "silent", expect: LevelSilent},
+		"error":   {input: "error", expect: slog.LevelError},
+		"info":    {input: "info", expect: slog.LevelInfo},
+		"default": {input: "default", expect: slog.LevelInfo},
+		"debug":   {input: "DEBUG", expect: slog.LevelDebug},
+		"trace":   {input: "trace", expect: LevelTrace},
+		"unknown": {input: "verbose", expect: slog.LevelInfo, err: true},
+	}
+	for desc, tc := range tests {
+		got, err := LogLevelFromString(tc.input)
+		if tc.err {
+			assert.Error(t, err, desc)
+		} else {
+			assert.NoError(t, err, desc)
+		}
+		assert.Equal(t, tc.expect, got, desc)
+	}
+}
+
+func TestNewLogger(t *testing.T) {
+	var buf bytes.Buffer
+	l := newLogger(&buf, LevelTrace, "text")
+	l.Log(context.Background(), LevelTrace, "dialed", "addr", "127.0.0.1:5432")
+	assert.Contains(t, buf.String(), `level=TRACE msg=dialed addr=127.0.0.1:5432`)
+
+	buf.Reset()
+	l = newLogger(&buf, slog.LevelInfo, "json")
+	l.Debug("hidden")
+	l.Info("shown", "target", "db.example.com")
+	assert.NotContains(t, buf.String(), "hidden")
+	assert.Contains(t, buf.String(), `"msg":"shown","target":"db.example.com"`)
+
+	buf.Reset()
+	l = newLogger(&buf, LevelSilent, "text")
+	l.Error("hidden")
+	assert.Empty(t, buf.String())
+}
+
+func TestLogln(t *testing.T) {
+	var buf bytes.Buffer
+	results = &buf
+	defer func() {
+		results = os.Stdout
+		silent = false
+	}()
+
+	logln("status=OK")
+	silent = true
+	logln("status=FAIL")
+	assert.Equal(t, "status=OK\n", buf.String())
+}
+
+func TestLogOutputs(t *testing.T) {
+	var text, json bytes.Buffer
+	outputs = []Output{{Format: "text", Writer: &text}, {Format: "json", Writer: &json}}
+	defer func() {
+		outputs = nil
+	}()
+
+	logOutputs(func(format string) []string {
+		return []string{format + " line"}
+	})
+	assert.Equal(t, "text line\n", text.String())
+	assert.Equal(t, "json line\n", json.String())
+}
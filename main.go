@@ -4,19 +4,64 @@ import (
 	"context"
 	"fmt"
 	"os"
+	"os/signal"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
 
 	"github.com/alecthomas/kingpin"
-	"github.com/jackc/pgx/v5"
 	"golang.org/x/term"
 )
 
+// envService is $PGSERVICE, which is taken out of the environment at
+// startup.
+var envService string
+
 var (
-	count   = kingpin.Flag("count", "stop after N pings").Default("-1").Short('c').Int()
-	wait    = kingpin.Flag("wait", "wait time between sending each ping").Default("1s").Short('i').Duration()
-	timeout = kingpin.Flag("timeout", "timeout for connections to the DB").Default("5s").Short('t').Duration()
-	query   = kingpin.Flag("query", "Test query to execute on database").Default("SELECT 1").String()
+	count          = kingpin.Flag("count", "stop after N pings").Default("-1").Short('c').Int()
+	wait           = kingpin.Flag("wait", "wait time between sending each ping").Default("1s").Short('i').Duration()
+	timeout        = kingpin.Flag("timeout", "timeout for each ping as a whole").Default("5s").Short('t').Duration()
+	connectTimeout = kingpin.Flag("connect-timeout", "timeout for connecting, within --timeout").Duration()
+	queryTimeout   = kingpin.Flag("query-timeout", "timeout for the query and checks, within --timeout; also set as statement_timeout and enforced with a cancel request").Duration()
+	closeTimeout   = kingpin.Flag("close-timeout", "timeout for closing the connection, within --timeout").Duration()
+	query          = kingpin.Flag("query", "Test query to execute on database (repeatable, default SELECT 1)").Strings()
+	queryFile      = kingpin.Flag("query-file", "read named queries to execute on database from a file").String()
+
+	expectValue   = kingpin.Flag("expect-value", "fail unless the first column of the first row is exactly this").String()
+	expectCompare = kingpin.Flag("expect-compare", "fail unless the first column of the first row is a number that compares like this (e.g. \"< 10\")").String()
+	expectRegex   = kingpin.Flag("expect-regex", "fail unless the first column of the first row matches this regular expression").String()
+	expectRows    = kingpin.Flag("expect-rows", "fail unless the number of rows compares like this (e.g. 3, \">= 1\")").String()
+	expectType    = kingpin.Flag("expect-type", "fail unless the first column has this data type (e.g. int8, text)").String()
+
+	persistent = kingpin.Flag("persistent", "hold one connection open and run the query on it every ping, reconnecting when it breaks").Bool()
+
+	checkRole = kingpin.Flag("role", "check whether the server is a primary or standby and its timeline on every ping").Bool()
+
+	pool                  = kingpin.Flag("pool", "go through a pgxpool pool like services do: every ping acquires a connection, runs the query and releases it").Bool()
+	poolMinConns          = kingpin.Flag("pool-min-conns", "connections the pool keeps open even when idle").Default("0").Int()
+	poolMaxConns          = kingpin.Flag("pool-max-conns", "most connections the pool opens").Default("4").Int()
+	poolHealthCheckPeriod = kingpin.Flag("pool-health-check-period", "how often the pool checks its idle connections").Default("1m").Duration()
+	poolMaxConnLifetime   = kingpin.Flag("pool-max-conn-lifetime", "how long a pool connection lives before it's replaced").Default("1h").Duration()
+
+	check         = kingpin.Flag("check", "what to check on top of the query (ping, replication, write, visibility); visibility writes a heartbeat on the first target and times until it shows up on the others").Default(CheckPing).Enum(CheckPing, CheckReplication, CheckWrite, CheckVisibility)
+	replWarnLag   = kingpin.Flag("replication-warn-lag", "WARN when replication lag reaches this").Duration()
+	replCritLag   = kingpin.Flag("replication-crit-lag", "FAIL when replication lag reaches this").Duration()
+	replWarnBytes = kingpin.Flag("replication-warn-bytes", "WARN when replication lag or WAL retained by slots reaches this many bytes (e.g. 64MB)").Bytes()
+	replCritBytes = kingpin.Flag("replication-crit-bytes", "FAIL when replication lag or WAL retained by slots reaches this many bytes (e.g. 1GB)").Bytes()
+
+	heartbeatTable  = kingpin.Flag("heartbeat-table", "table --check write upserts its heartbeat row into").Default("pgping_heartbeat").String()
+	heartbeatClient = kingpin.Flag("heartbeat-client", "key of the heartbeat row written by --check write (default: hostname)").String()
+	heartbeatCreate = kingpin.Flag("heartbeat-create", "create the heartbeat table if it doesn't exist").Bool()
+
+	visibilityTimeout      = kingpin.Flag("visibility-timeout", "FAIL a replica when the heartbeat doesn't show up on it within this").Default("10s").Duration()
+	visibilityPollInterval = kingpin.Flag("visibility-poll-interval", "how often to poll replicas for the heartbeat").Default("10ms").Duration()
+
+	flood               = kingpin.Flag("flood", "open connections and run the query from --concurrency goroutines as fast as possible (or at --rate) and report throughput, latency percentiles and errors").Bool()
+	floodConcurrency    = kingpin.Flag("concurrency", "number of goroutines pinging at once in --flood mode").Default("10").Int()
+	floodRate           = kingpin.Flag("rate", "cap --flood at this many pings per second across all goroutines (0 for no cap)").Default("0").Float64()
+	floodDuration       = kingpin.Flag("duration", "stop --flood after this long").Duration()
+	floodReportInterval = kingpin.Flag("report-interval", "how often --flood reports").Default("5s").Duration()
 
 	pgHost     = kingpin.Flag("pg-host", "").String()
 	pgPort     = kingpin.Flag("pg-port", "").String()
@@ -25,62 +70,49 @@ var (
 	pgPassword = kingpin.Flag("pg-password", "").String()
 	pgSSLMode  = kingpin.Flag("pg-sslmode", "").String()
 	pgAppName  = kingpin.Flag("pg-app-name", "").Default("pgping/" + VERSION).String()
+	pgService  = kingpin.Flag("service", "pg_service.conf service to take connection parameters from").String()
+
+	pgSSLFlags = map[string]*string{
+		"sslrootcert": kingpin.Flag("pg-sslrootcert", "file of CA certificates to verify the server certificate against").String(),
+		"sslcert":     kingpin.Flag("pg-sslcert", "client certificate file").String(),
+		"sslkey":      kingpin.Flag("pg-sslkey", "client private key file").String(),
+		"sslpassword": kingpin.Flag("pg-sslpassword", "password to decrypt the client private key").String(),
+		"sslcrl":      kingpin.Flag("pg-sslcrl", "certificate revocation list file to check the server certificate against").String(),
+		"sslsni":      kingpin.Flag("pg-sslsni", "send the host name via SNI (1 or 0)").String(),
+	}
+
+	tlsPins      = kingpin.Flag("tls-pin", "fail unless the server certificate or one in its chain has this fingerprint (sha256:<hex>, repeatable)").Strings()
+	certWarnDays = kingpin.Flag("tls-warn-days", "WARN when the server certificate expires in fewer than this many days").Int()
+	certCritDays = kingpin.Flag("tls-crit-days", "FAIL when the server certificate expires in fewer than this many days").Int()
+
+	pgTargetSessionAttrs = kingpin.Flag("pg-target-session-attrs", "which of several hosts to accept (any, read-write, read-only, primary, standby, prefer-standby)").String()
 
 	promptPassword = kingpin.Flag("prompt-password", "prompt for password").Short('p').Bool()
-	logLevel       = kingpin.Flag("log-level", "log level (default, debug)").Default("default").String()
+	logLevel       = kingpin.Flag("log-level", "log level (silent, error, info, debug, trace)").Default("info").Enum("silent", "error", "info", "default", "debug", "trace")
+	logFormat      = kingpin.Flag("log-format", "format of diagnostic logs on stderr (text, json)").Default("text").Enum("text", "json")
+	outputFormat   = kingpin.Flag("output", "output format (text, json)").Short('o').Default("text").Enum("text", "json")
+	listenAddress  = kingpin.Flag("listen-address", "serve Prometheus metrics on this address (e.g. :9187)").String()
+
+	nagios         = kingpin.Flag("nagios", "run as a Nagios plugin: ping --count times (default 5), print one status line with perfdata and exit 0/1/2/3; any failed ping is CRITICAL unless --warning or --critical has a loss threshold").Bool()
+	nagiosWarning  = kingpin.Flag("warning", "Nagios WARNING threshold for average ping time and loss (e.g. 200ms,20%)").Short('w').String()
+	nagiosCritical = kingpin.Flag("critical", "Nagios CRITICAL threshold for average ping time and loss (e.g. 1s,60%); with --nagios, -c takes it too like check_ping's does, as long as it isn't a plain number of pings").Short('C').String()
+
+	targetsFile = kingpin.Flag("targets-file", "read additional targets from a file, one per line").String()
+	configFile  = kingpin.Flag("config", "read named targets, their settings and outputs from a YAML file").String()
 
-	target = kingpin.Arg("target", "").String()
+	targets = kingpin.Arg("target", "").Strings()
 )
 
 func kv(key string, value any) string {
 	switch v := value.(type) {
 	case string, error:
 		value = fmt.Sprintf("%q", v)
+	case time.Time:
+		value = v.Format(timestampFormat)
 	}
 	return fmt.Sprintf("%s=%v", key, value)
 }
 
-func result(i int, start time.Time, kvs ...string) time.Duration {
-	if kvs == nil {
-		kvs = make([]string, 0)
-	}
-	duration := time.Since(start)
-	kvs = append(kvs, kv("i", i))
-	kvs = append(kvs, kv("duration", duration))
-	var format strings.Builder
-	format.WriteString(fmt.Sprintf("%-25s", time.Now().Format("2006-01-02T15:04:05.999Z")))
-	for i, label := range kvs {
-		if i > 0 {
-			format.WriteString(" ")
-		}
-		format.WriteString(label)
-	}
-	logln(format.String())
-	return duration
-}
-
-func ping(parent context.Context, connConfig *pgx.ConnConfig, i int) (bool, time.Duration) {
-	ctx, cancel := context.WithTimeout(parent, *timeout)
-	defer cancel()
-	start := time.Now()
-	conn, err := pgx.ConnectConfig(ctx, connConfig)
-	if err != nil {
-		return false, result(i, start, kv("status", "ERR"), kv("msg", "error connecting"), kv("err", err))
-	}
-	rows, err := conn.Query(ctx, *query)
-	if err != nil {
-		return false, result(i, start, kv("status", "ERR"), kv("msg", "error querying"), kv("err", err))
-	}
-	err = conn.Close(ctx)
-	if err != nil {
-		return false, result(i, start, kv("status", "ERR"), kv("msg", "error closing"), kv("err", err))
-	}
-	if rows.Next() {
-		return true, result(i, start, kv("status", "OK"), kv("host", connConfig.Host))
-	}
-	return false, result(i, start, kv("status", "FAIL"), kv("host", connConfig.Host), kv("msg", "0 rows returned"))
-}
-
 func readPassword(prompt string) (string, error) {
 	fmt.Print(prompt)
 	bytepw, err := term.ReadPassword(int(os.Stderr.Fd()))
@@ -91,62 +123,351 @@ func readPassword(prompt string) (string, error) {
 	return string(bytepw), nil
 }
 
-func buildTarget() *Target {
+func readTargetsFile(path string) ([]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var targets []string
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		targets = append(targets, line)
+	}
+	return targets, nil
+}
+
+// buildTarget layers the sources of connection parameters for a target given
+// as connString, or defined in the config file as config.
+func buildTarget(connString string, config *TargetConfig) *Target {
 	t := &Target{}
 	var err error
 	err = t.FromEnv(os.Getenv)
 	if err != nil {
-		panic(err)
+		fatalf("%v", err)
+	}
+	// like libpq, service definitions sit between the environment and anything
+	// given explicitly, and a service in the target string beats one in the
+	// config file, which beats --service, which beats $PGSERVICE.
+	service, connString := splitService(connString)
+	if service == "" && config != nil {
+		service = config.Service
+	}
+	if service == "" && pgService != nil {
+		service = *pgService
+	}
+	if service == "" {
+		service = envService
+	}
+	err = t.FromService(service, os.Getenv)
+	if err != nil {
+		fatalf("%v", err)
 	}
 	err = t.FromFlags()
 	if err != nil {
-		panic(err)
+		fatalf("%v", err)
 	}
-	if target != nil && *target != "" {
-		err = t.FromConnString(*target)
+	// a target's own settings in the config file beat the flags shared by
+	// every target, short of its connection string.
+	if config != nil {
+		err = t.FromConfig(config)
 		if err != nil {
-			panic(err)
+			fatalf("%v", err)
+		}
+	}
+	if connString != "" {
+		err = t.FromConnString(connString)
+		if err != nil {
+			fatalf("%v", err)
 		}
 	}
 	err = t.FromNetrc("")
 	if err != nil {
-		panic(err)
+		fatalf("%v", err)
+	}
+	err = t.FromPgpass("")
+	if err != nil {
+		fatalf("%v", err)
 	}
+	return t
+}
+
+// buildTargets returns the target strings given on the command line and in
+// the targets file, then the names of those in the config file, alongside
+// the Target built from each one and, for those from the config file, their
+// settings. With no targets at all a single target is built from the
+// environment and flags.
+func buildTargets(config *Config) ([]string, []*Target, []*TargetConfig) {
+	connStrings := []string{}
+	if targets != nil {
+		connStrings = append(connStrings, *targets...)
+	}
+	if targetsFile != nil && *targetsFile != "" {
+		fromFile, err := readTargetsFile(*targetsFile)
+		if err != nil {
+			fatalf("%v", err)
+		}
+		connStrings = append(connStrings, fromFile...)
+	}
+	configs := make([]*TargetConfig, len(connStrings))
+	if config != nil {
+		for _, target := range config.Targets {
+			connStrings = append(connStrings, target.Name)
+			configs = append(configs, target)
+		}
+	}
+	if len(connStrings) == 0 {
+		connStrings = append(connStrings, "")
+		configs = append(configs, nil)
+	}
+	password := ""
 	if promptPassword != nil && *promptPassword {
-		password, err := readPassword("Password: ")
+		var err error
+		password, err = readPassword("Password: ")
 		if err != nil {
-			panic(err)
+			fatalf("%v", err)
 		}
-		t.Password = password
 	}
-	return t
+	ts := make([]*Target, 0, len(connStrings))
+	for i, connString := range connStrings {
+		logger.Debug("building target", "target", redactConnString(connString))
+		var t *Target
+		if configs[i] != nil {
+			t = buildTarget(configs[i].ConnString, configs[i])
+		} else {
+			t = buildTarget(connString, nil)
+		}
+		if password != "" {
+			t.Password = password
+		}
+		ts = append(ts, t)
+	}
+	return connStrings, ts, configs
+}
+
+// uniqueLabel numbers label if it's already in labels, the same target given
+// twice say, so that the two don't share stats and metric series, and adds
+// the result to labels.
+func uniqueLabel(labels map[string]bool, label string) string {
+	unique := label
+	for n := 2; labels[unique]; n++ {
+		unique = fmt.Sprintf("%s#%d", label, n)
+	}
+	labels[unique] = true
+	return unique
 }
 
 func main() {
 	kingpin.CommandLine.HelpFlag.Short('h')
-	debugln("Parsing command-line flags")
-	kingpin.Parse()
+	logger.Debug("parsing command-line flags")
+	args, nagiosRequested, err := nagiosMode(os.Args[1:])
+	if err == nil {
+		if nagiosRequested {
+			args = nagiosArgs(args)
+		}
+		_, err = kingpin.CommandLine.Parse(args)
+	}
+	if err != nil {
+		if nagiosRequested {
+			nagiosUnknown(err)
+		}
+		kingpin.Fatalf("%s, try --help", err)
+	}
+	envService = takeEnvService()
+	err = setupLogging()
+	if err != nil {
+		fatalf("%v", err)
+	}
+	var warning, critical Threshold
+	if *nagios {
+		warning, err = ParseThreshold(*nagiosWarning)
+		if err != nil {
+			nagiosUnknown(err)
+		}
+		critical, err = ParseThreshold(*nagiosCritical)
+		if err != nil {
+			nagiosUnknown(err)
+		}
+		if *count == -1 {
+			*count = 5
+		}
+		// the status line is the only output a plugin may have
+		silent = true
+	}
 	ctx := context.Background()
-	debugln("Building target")
-	t := buildTarget()
+	logger.Debug("building targets")
+	var config *Config
+	if *configFile != "" {
+		config, err = ReadConfig(*configFile)
+		if err != nil {
+			fatalf("%v", err)
+		}
+		outputs, err = openOutputs(config.Outputs)
+		if err != nil {
+			fatalf("%v", err)
+		}
+	}
+	connStrings, ts, configs := buildTargets(config)
+
+	var metrics *Metrics
+	if *listenAddress != "" {
+		metrics = NewMetrics()
+	}
 
-	debugln("Converting target to conn config")
-	connConfig, err := t.ToConnConfig()
+	spec := AssertionSpec{
+		Value:   *expectValue,
+		Compare: *expectCompare,
+		Regex:   *expectRegex,
+		Rows:    *expectRows,
+		Type:    *expectType,
+	}
+	queries, err := buildQueries(*query, *queryFile, spec)
 	if err != nil {
-		panic(err)
+		fatalf("%v", err)
+	}
+	pins, err := parsePins(*tlsPins)
+	if err != nil {
+		fatalf("%v", err)
+	}
+	if *flood && (*floodConcurrency < 1 || *floodRate < 0 || *floodReportInterval <= 0) {
+		fatalf("--flood needs a --concurrency of at least 1, a --rate that isn't negative and a positive --report-interval")
+	}
+	if *connectTimeout < 0 || *queryTimeout < 0 || *closeTimeout < 0 {
+		fatalf("--connect-timeout, --query-timeout and --close-timeout can't be negative")
+	}
+	if *pool && *persistent {
+		fatalf("--pool and --persistent can't be combined")
+	}
+	if *pool && (*poolMaxConns < 1 || *poolMinConns < 0 || *poolMinConns > *poolMaxConns) {
+		fatalf("--pool needs a --pool-max-conns of at least 1 and a --pool-min-conns between 0 and it")
+	}
+	if *pool && (*poolHealthCheckPeriod <= 0 || *poolMaxConnLifetime <= 0) {
+		fatalf("--pool needs a positive --pool-health-check-period and --pool-max-conn-lifetime")
+	}
+	if *flood && *check == CheckVisibility {
+		fatalf("--flood can't be combined with --check visibility")
+	}
+	if *check == CheckVisibility && len(ts) < 2 {
+		fatalf("--check visibility needs a primary target followed by at least one replica target")
+	}
+
+	pingers := make([]*Pinger, 0, len(ts))
+	labels := make(map[string]bool, len(ts))
+	for i, t := range ts {
+		logger.Debug("converting target to conn config", "target", redactConnString(connStrings[i]))
+		connConfig, err := t.ToConnConfig()
+		if err != nil {
+			fatalf("%v", err)
+		}
+		if len(pins) > 0 {
+			err = applyPins(connConfig, pins)
+			if err != nil {
+				fatalf("%s: %v", t.Host, err)
+			}
+		}
+		instrumentConnConfig(connConfig)
+		label := ""
+		if len(ts) > 1 {
+			label = targetLabel(connConfig)
+			if configs[i] != nil {
+				label = configs[i].Name
+			}
+			label = uniqueLabel(labels, label)
+		}
+		pinger := NewPinger(label, connConfig)
+		pinger.Metrics = metrics
+		pinger.Queries = queries
+		if configs[i] != nil {
+			err = configs[i].apply(pinger, spec)
+			if err != nil {
+				fatalf("%v", err)
+			}
+		}
+		if *flood && pinger.Check == CheckWrite {
+			// every goroutine would upsert the same heartbeat row and read
+			// back the others' writes
+			fatalf("--flood can't be combined with --check write")
+		}
+		err = pinger.defaultHeartbeatClient()
+		if err != nil {
+			fatalf("error getting hostname for --heartbeat-client: %v", err)
+		}
+		pinger.enforceQueryTimeout()
+		if pinger.Pool != nil {
+			err = pinger.openPool()
+			if err != nil {
+				fatalf("error creating pool: %v", err)
+			}
+		}
+		pingers = append(pingers, pinger)
 	}
 
-	for i := 1; *count == -1 || i <= *count; i++ {
-		pass, duration := ping(ctx, connConfig, i)
-		if i == *count {
-			if !pass {
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if metrics != nil {
+		go func() {
+			err := metrics.Serve(ctx, *listenAddress)
+			if err != nil {
+				logger.Error("error serving metrics", "addr", *listenAddress, "err", err)
 				os.Exit(1)
 			}
-			os.Exit(0)
+		}()
+	}
+
+	failed := make([]bool, len(pingers))
+	if *check == CheckVisibility {
+		failed = NewVisibilityProbe(pingers[0], pingers[1:]).Run(ctx)
+	} else {
+		var wg sync.WaitGroup
+		for i, pinger := range pingers {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if *flood {
+					failed[i] = NewFlood(pinger).Run(ctx)
+					return
+				}
+				failed[i] = pinger.Run(ctx)
+			}()
 		}
-		timeUntilNext := *wait - duration
-		if timeUntilNext > 0 {
-			time.Sleep(timeUntilNext)
+		wg.Wait()
+	}
+
+	if *nagios {
+		checks := make([]nagiosCheck, 0, len(pingers))
+		for _, pinger := range pingers {
+			checks = append(checks, checkNagios(pinger.Label, pinger.Stats, warning, critical))
 		}
+		line, state := nagiosReport(checks)
+		fmt.Println(line)
+		os.Exit(state)
 	}
+
+	exitCode := 0
+	for i, pinger := range pingers {
+		printSummary(pinger.Name(), pinger.Stats)
+		if failed[i] {
+			exitCode = 1
+		}
+	}
+	os.Exit(exitCode)
+}
+
+// fatalf reports a problem that keeps pgping from starting and exits. As a
+// Nagios plugin it prints the UNKNOWN status line instead.
+func fatalf(format string, a ...any) {
+	if *nagios {
+		nagiosUnknown(fmt.Errorf(format, a...))
+	}
+	kingpin.Fatalf(format, a...)
+}
+
+// nagiosUnknown reports a problem that keeps the Nagios check from running at
+// all.
+func nagiosUnknown(err error) {
+	fmt.Printf("PGPING %s - %v\n", nagiosStateNames[NagiosUnknown], err)
+	os.Exit(NagiosUnknown)
 }
@@ -1,8 +1,11 @@
 package main
 
 import (
+	"os"
 	"testing"
 	"time"
+
+	"github.com/stretchr/testify/assert"
 )
 
 func TestKv(t *testing.T) {
@@ -26,6 +29,11 @@ func TestKv(t *testing.T) {
 			value:  "test",
 			expect: `string="test"`,
 		},
+		{
+			key:    "time",
+			value:  time.Date(2023, 3, 30, 15, 41, 14, 500000000, time.UTC),
+			expect: "time=2023-03-30T15:41:14.5Z",
+		},
 	}
 	for _, tc := range tests {
 		got := kv(tc.key, tc.value)
@@ -34,3 +42,37 @@ func TestKv(t *testing.T) {
 		}
 	}
 }
+
+func TestReadTargetsFile(t *testing.T) {
+	file, err := os.CreateTemp("", "targets")
+	if err != nil {
+		t.Fatalf("error creating temp file %v", err)
+	}
+	defer os.Remove(file.Name())
+	_, err = file.WriteString("# primary\nprimary.example.com\n\n  replica1.example.com:5433  \nuser@replica2.example.com\n")
+	if err != nil {
+		t.Fatalf("error writing temp file %v", err)
+	}
+	err = file.Close()
+	if err != nil {
+		t.Fatalf("error closing temp file %v", err)
+	}
+	got, err := readTargetsFile(file.Name())
+	if err != nil {
+		t.Fatalf("error %v", err)
+	}
+	assert.Equal(t, []string{
+		"primary.example.com",
+		"replica1.example.com:5433",
+		"user@replica2.example.com",
+	}, got)
+}
+
+func TestUniqueLabel(t *testing.T) {
+	labels := map[string]bool{}
+	got := []string{}
+	for _, label := range []string{"a", "b", "a", "a#2", "a"} {
+		got = append(got, uniqueLabel(labels, label))
+	}
+	assert.Equal(t, []string{"a", "b", "a#2", "a#2#2", "a#3"}, got)
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Metrics exports ping results in the Prometheus format. A nil *Metrics is
+// valid and drops everything, which is what runs without --listen-address
+// use.
+type Metrics struct {
+	registry             *prometheus.Registry
+	duration             *prometheus.HistogramVec
+	phases               *prometheus.HistogramVec
+	pings                *prometheus.CounterVec
+	lastSuccess          *prometheus.GaugeVec
+	up                   *prometheus.GaugeVec
+	lagBytes             *prometheus.GaugeVec
+	lagSeconds           *prometheus.GaugeVec
+	certExpiry           *prometheus.GaugeVec
+	visibility           *prometheus.GaugeVec
+	poolConns            *prometheus.GaugeVec
+	poolCanceledAcquires *prometheus.CounterVec
+
+	mu sync.Mutex
+	// canceledAcquires is the canceled acquires count of each target's pool
+	// as of the last ping, as pools only report their running total.
+	canceledAcquires map[string]int64
+}
+
+func NewMetrics() *Metrics {
+	buckets := prometheus.ExponentialBuckets(0.001, 2, 15)
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: "pgping",
+			Name:      "ping_duration_seconds",
+			Help:      "Duration of each ping.",
+			Buckets:   buckets,
+		}, []string{"target"}),
+		phases: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: "pgping",
+			Name:      "phase_duration_seconds",
+			Help:      "Duration of each phase of a ping.",
+			Buckets:   buckets,
+		}, []string{"target", "phase"}),
+		pings: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "pgping",
+			Name:      "pings_total",
+			Help:      "Number of pings by status and error class.",
+		}, []string{"target", "status", "error_class"}),
+		lastSuccess: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: "pgping",
+			Name:      "last_success_timestamp_seconds",
+			Help:      "Unix time of the last successful ping.",
+		}, []string{"target"}),
+		up: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: "pgping",
+			Name:      "up",
+			Help:      "Whether the last ping succeeded.",
+		}, []string{"target"}),
+		lagBytes: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: "pgping",
+			Name:      "replication_lag_bytes",
+			Help:      "Replication lag in bytes from the last replication check.",
+		}, []string{"target", "role"}),
+		lagSeconds: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: "pgping",
+			Name:      "replication_lag_seconds",
+			Help:      "Replication lag in seconds from the last replication check.",
+		}, []string{"target", "role"}),
+		certExpiry: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: "pgping",
+			Name:      "tls_cert_expiry_timestamp_seconds",
+			Help:      "Unix time the first certificate of the server's chain expires.",
+		}, []string{"target"}),
+		visibility: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: "pgping",
+			Name:      "replication_visibility_seconds",
+			Help:      "How long the last heartbeat written on the primary took to show up on the replica.",
+		}, []string{"target"}),
+		poolConns: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: "pgping",
+			Name:      "pool_connections",
+			Help:      "Connections of the pool by state after the last ping in pool mode.",
+		}, []string{"target", "state"}),
+		poolCanceledAcquires: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "pgping",
+			Name:      "pool_canceled_acquires_total",
+			Help:      "Acquires from the pool that were canceled before getting a connection.",
+		}, []string{"target"}),
+		canceledAcquires: make(map[string]int64),
+	}
+	m.registry.MustRegister(
+		m.duration,
+		m.phases,
+		m.pings,
+		m.lastSuccess,
+		m.up,
+		m.lagBytes,
+		m.lagSeconds,
+		m.certExpiry,
+		m.visibility,
+		m.poolConns,
+		m.poolCanceledAcquires,
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	return m
+}
+
+func (m *Metrics) Observe(target string, res PingResult) {
+	if m == nil {
+		return
+	}
+	m.duration.WithLabelValues(target).Observe(res.Duration.Seconds())
+	for phase, d := range res.Phases {
+		m.phases.WithLabelValues(target, string(phase)).Observe(d.Seconds())
+	}
+	m.pings.WithLabelValues(target, res.Status, res.ErrorClass()).Inc()
+	if res.Pass() {
+		m.lastSuccess.WithLabelValues(target).Set(float64(res.Time.UnixNano()) / float64(time.Second))
+		m.up.WithLabelValues(target).Set(1)
+	} else {
+		m.up.WithLabelValues(target).Set(0)
+	}
+	if res.TLS != nil && !res.TLS.Expiry.IsZero() {
+		m.certExpiry.WithLabelValues(target).Set(float64(res.TLS.Expiry.Unix()))
+	}
+	if res.Visibility != 0 {
+		m.visibility.WithLabelValues(target).Set(res.Visibility.Seconds())
+	}
+	if res.Pool != nil {
+		m.poolConns.WithLabelValues(target, "idle").Set(float64(res.Pool.Idle))
+		m.poolConns.WithLabelValues(target, "in_use").Set(float64(res.Pool.InUse))
+		m.poolConns.WithLabelValues(target, "constructing").Set(float64(res.Pool.Constructing))
+		m.mu.Lock()
+		canceled := res.Pool.CanceledAcquires - m.canceledAcquires[target]
+		m.canceledAcquires[target] = res.Pool.CanceledAcquires
+		m.mu.Unlock()
+		m.poolCanceledAcquires.WithLabelValues(target).Add(float64(max(canceled, 0)))
+	}
+	if res.Replication != nil {
+		// the role may have changed since the last check
+		m.lagBytes.DeletePartialMatch(prometheus.Labels{"target": target})
+		m.lagSeconds.DeletePartialMatch(prometheus.Labels{"target": target})
+		m.lagBytes.WithLabelValues(target, res.Replication.Role).Set(float64(res.Replication.LagBytes))
+		m.lagSeconds.WithLabelValues(target, res.Replication.Role).Set(res.Replication.Lag.Seconds())
+	}
+}
+
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// Serve serves /metrics on addr until ctx is cancelled.
+func (m *Metrics) Serve(ctx context.Context, addr string) error {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", m.Handler())
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		err := server.Shutdown(shutdownCtx)
+		if err != nil {
+			logger.Error("error shutting down metrics server", "err", err)
+		}
+	}()
+	logger.Info("serving metrics", "addr", addr)
+	err := server.ListenAndServe()
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMetricsObserve(t *testing.T) {
+	m := NewMetrics()
+	m.Observe("primary", PingResult{
+		Time:     time.Unix(1680190874, 0),
+		Status:   StatusOK,
+		Duration: 3 * time.Millisecond,
+		Phases: map[Phase]time.Duration{
+			PhaseQuery: 2 * time.Millisecond,
+		},
+		Pool: &PoolStats{Total: 3, Idle: 2, InUse: 1, Max: 4, CanceledAcquires: 5},
+	})
+	m.Observe("replica", PingResult{
+		Time:     time.Unix(1680190874, 0),
+		Status:   StatusErr,
+		Err:      context.DeadlineExceeded,
+		Duration: 5 * time.Second,
+	})
+	m.Observe("standby", PingResult{
+		Time:        time.Unix(1680190874, 0),
+		Status:      StatusWarn,
+		Duration:    3 * time.Millisecond,
+		Replication: &Replication{Role: RoleStandby, LagBytes: 4096, Lag: 1500 * time.Millisecond},
+		TLS:         &TLSInfo{Version: "TLS 1.3", Expiry: time.Unix(1700000000, 0)},
+	})
+
+	rec := httptest.NewRecorder()
+	m.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body, err := io.ReadAll(rec.Body)
+	if err != nil {
+		t.Fatalf("error %v", err)
+	}
+	for _, line := range []string{
+		`pgping_up{target="primary"} 1`,
+		`pgping_up{target="replica"} 0`,
+		`pgping_last_success_timestamp_seconds{target="primary"} 1.680190874e+09`,
+		`pgping_pings_total{error_class="",status="OK",target="primary"} 1`,
+		`pgping_pings_total{error_class="timeout",status="ERR",target="replica"} 1`,
+		`pgping_ping_duration_seconds_count{target="primary"} 1`,
+		`pgping_phase_duration_seconds_count{phase="query",target="primary"} 1`,
+		`pgping_up{target="standby"} 1`,
+		`pgping_replication_lag_bytes{role="standby",target="standby"} 4096`,
+		`pgping_replication_lag_seconds{role="standby",target="standby"} 1.5`,
+		`pgping_tls_cert_expiry_timestamp_seconds{target="standby"} 1.7e+09`,
+		`pgping_pool_connections{state="idle",target="primary"} 2`,
+		`pgping_pool_connections{state="in_use",target="primary"} 1`,
+		`pgping_pool_connections{state="constructing",target="primary"} 0`,
+		`pgping_pool_canceled_acquires_total{target="primary"} 5`,
+	} {
+		assert.Contains(t, string(body), line)
+	}
+	assert.NotContains(t, string(body), `pgping_last_success_timestamp_seconds{target="replica"}`)
+}
+
+func TestMetricsPoolCanceledAcquires(t *testing.T) {
+	m := NewMetrics()
+	for _, canceled := range []int64{0, 2, 2, 7} {
+		m.Observe("primary", PingResult{Status: StatusOK, Pool: &PoolStats{CanceledAcquires: canceled}})
+	}
+	m.Observe("replica", PingResult{Status: StatusOK, Pool: &PoolStats{CanceledAcquires: 1}})
+
+	rec := httptest.NewRecorder()
+	m.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body, err := io.ReadAll(rec.Body)
+	if err != nil {
+		t.Fatalf("error %v", err)
+	}
+	assert.Contains(t, string(body), "# TYPE pgping_pool_canceled_acquires_total counter")
+	assert.Contains(t, string(body), `pgping_pool_canceled_acquires_total{target="primary"} 7`)
+	assert.Contains(t, string(body), `pgping_pool_canceled_acquires_total{target="replica"} 1`)
+}
+
+func TestMetricsNil(t *testing.T) {
+	var m *Metrics
+	m.Observe("primary", PingResult{Status: StatusOK})
+}
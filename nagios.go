@@ -0,0 +1,220 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Nagios plugin states, which double as the exit codes.
+const (
+	NagiosOK       = 0
+	NagiosWarning  = 1
+	NagiosCritical = 2
+	NagiosUnknown  = 3
+)
+
+var nagiosStateNames = map[int]string{
+	NagiosOK:       "OK",
+	NagiosWarning:  "WARNING",
+	NagiosCritical: "CRITICAL",
+	NagiosUnknown:  "UNKNOWN",
+}
+
+// nagiosMode reports whether args ask to run as a Nagios plugin, which has
+// to be known before they're parsed for parse errors to come out as UNKNOWN.
+// Like any other flag the last --nagios or --no-nagios wins. kingpin takes the
+// value of --nagios=<bool> for an argument of its own, so those are rewritten
+// to the plain flags.
+func nagiosMode(args []string) ([]string, bool, error) {
+	out := make([]string, 0, len(args))
+	nagios := false
+	for i, arg := range args {
+		if arg == "--" {
+			return append(out, args[i:]...), nagios, nil
+		}
+		switch {
+		case arg == "--nagios":
+			nagios = true
+		case arg == "--no-nagios":
+			nagios = false
+		case strings.HasPrefix(arg, "--nagios="):
+			value, err := strconv.ParseBool(strings.TrimPrefix(arg, "--nagios="))
+			if err != nil {
+				return nil, true, fmt.Errorf("invalid value `%s` for --nagios", strings.TrimPrefix(arg, "--nagios="))
+			}
+			nagios = value
+			if value {
+				arg = "--nagios"
+			} else {
+				arg = "--no-nagios"
+			}
+		}
+		out = append(out, arg)
+	}
+	return out, nagios, nil
+}
+
+// nagiosArgs lets -c give the CRITICAL threshold when running as a Nagios
+// plugin, as in check_ping's `-w 200ms,20% -c 1s,60%`, even though -c is
+// short for --count. Thresholds always have a unit and counts never do, so a
+// -c followed by a whole number stays --count and anything else is rewritten
+// to --critical.
+func nagiosArgs(args []string) []string {
+	out := make([]string, 0, len(args))
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" {
+			return append(out, args[i:]...)
+		}
+		value, next := "", false
+		switch {
+		case arg == "-c" && i+1 < len(args):
+			value, next = args[i+1], true
+		case strings.HasPrefix(arg, "-c") && len(arg) > 2:
+			value = arg[2:]
+		default:
+			out = append(out, arg)
+			continue
+		}
+		if _, err := strconv.Atoi(value); err == nil {
+			out = append(out, arg)
+			continue
+		}
+		out = append(out, "--critical="+value)
+		if next {
+			i++
+		}
+	}
+	return out
+}
+
+// Threshold is a --warning or --critical value: an average ping time and a
+// packet loss percentage, either of which may be left out.
+type Threshold struct {
+	Time    time.Duration
+	Loss    float64
+	HasLoss bool
+}
+
+// ParseThreshold parses thresholds in the style of check_ping, e.g.
+// "200ms,20%", "200ms" or "20%".
+func ParseThreshold(s string) (Threshold, error) {
+	var threshold Threshold
+	if s == "" {
+		return threshold, nil
+	}
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		switch {
+		case part == "":
+		case strings.HasSuffix(part, "%"):
+			loss, err := strconv.ParseFloat(strings.TrimSuffix(part, "%"), 64)
+			if err != nil {
+				return threshold, fmt.Errorf("invalid loss threshold `%s`", part)
+			}
+			threshold.Loss = loss
+			threshold.HasLoss = true
+		default:
+			d, err := time.ParseDuration(part)
+			if err != nil {
+				return threshold, fmt.Errorf("invalid time threshold `%s`", part)
+			}
+			threshold.Time = d
+		}
+	}
+	return threshold, nil
+}
+
+func (t Threshold) exceeded(avg time.Duration, loss float64) bool {
+	return (t.Time > 0 && avg >= t.Time) || (t.HasLoss && loss >= t.Loss)
+}
+
+// nagiosCheck is the verdict on one target.
+type nagiosCheck struct {
+	State    int
+	Summary  string
+	Perfdata []string
+}
+
+// checkNagios judges the pings to one target against the thresholds. name
+// prefixes the perfdata labels when there is more than one target. Unless one
+// of the thresholds has a loss, any failed ping is CRITICAL.
+func checkNagios(name string, stats *Stats, warning, critical Threshold) nagiosCheck {
+	avg := stats.Durations.Avg()
+	loss := stats.Loss()
+	check := nagiosCheck{State: NagiosOK}
+	switch {
+	case stats.Received == 0:
+		check.State = NagiosCritical
+	case critical.exceeded(avg, loss):
+		check.State = NagiosCritical
+	case !warning.HasLoss && !critical.HasLoss && stats.Failed() > 0:
+		check.State = NagiosCritical
+	case warning.exceeded(avg, loss):
+		check.State = NagiosWarning
+	case stats.Last.Status == StatusWarn:
+		check.State = NagiosWarning
+	}
+
+	summary := make([]string, 0, 3)
+	if name != "" {
+		summary = append(summary, name+":")
+	}
+	if stats.Received == 0 {
+		summary = append(summary, "no pings received")
+	} else {
+		summary = append(summary, fmt.Sprintf("avg %s, loss %.0f%%", avg.Round(10*time.Microsecond), loss))
+	}
+	if stats.Last.Msg != "" {
+		summary = append(summary, fmt.Sprintf("(%s)", stats.Last.Msg))
+	}
+	check.Summary = strings.Join(summary, " ")
+
+	label := func(s string) string {
+		if name == "" {
+			return s
+		}
+		return fmt.Sprintf("'%s_%s'", name, s)
+	}
+	seconds := func(d time.Duration) string {
+		if d == 0 {
+			return ""
+		}
+		return fmt.Sprintf("%f", d.Seconds())
+	}
+	percent := func(t Threshold) string {
+		if !t.HasLoss {
+			return ""
+		}
+		return strconv.FormatFloat(t.Loss, 'f', -1, 64)
+	}
+	check.Perfdata = []string{
+		fmt.Sprintf("%s=%fs;%s;%s;0", label("time"), avg.Seconds(), seconds(warning.Time), seconds(critical.Time)),
+		fmt.Sprintf("%s=%.0f%%;%s;%s;0;100", label("loss"), loss, percent(warning), percent(critical)),
+	}
+	return check
+}
+
+// nagiosReport combines the checks of every target into the single status
+// line a Nagios plugin prints and the state to exit with.
+func nagiosReport(checks []nagiosCheck) (string, int) {
+	state := NagiosOK
+	summaries := make([]string, 0, len(checks))
+	perfdata := make([]string, 0, 2*len(checks))
+	for _, check := range checks {
+		if check.State > state {
+			state = check.State
+		}
+		summaries = append(summaries, check.Summary)
+		perfdata = append(perfdata, check.Perfdata...)
+	}
+	line := fmt.Sprintf(
+		"PGPING %s - %s|%s",
+		nagiosStateNames[state],
+		strings.Join(summaries, ", "),
+		strings.Join(perfdata, " "),
+	)
+	return line, state
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseThreshold(t *testing.T) {
+	tests := map[string]struct {
+		input    string
+		expected Threshold
+		err      bool
+	}{
+		"empty": {
+			input:    "",
+			expected: Threshold{},
+		},
+		"time and loss": {
+			input:    "200ms,20%",
+			expected: Threshold{Time: 200 * time.Millisecond, Loss: 20, HasLoss: true},
+		},
+		"time only": {
+			input:    "1s",
+			expected: Threshold{Time: time.Second},
+		},
+		"loss only": {
+			input:    ",0%",
+			expected: Threshold{Loss: 0, HasLoss: true},
+		},
+		"bad time": {
+			input: "200,20%",
+			err:   true,
+		},
+		"bad loss": {
+			input: "200ms,x%",
+			err:   true,
+		},
+	}
+	for desc, tc := range tests {
+		threshold, err := ParseThreshold(tc.input)
+		if tc.err {
+			assert.Error(t, err, desc)
+			continue
+		}
+		assert.NoError(t, err, desc)
+		assert.Equal(t, tc.expected, threshold, desc)
+	}
+}
+
+func TestCheckNagios(t *testing.T) {
+	warning := Threshold{Time: 200 * time.Millisecond, Loss: 20, HasLoss: true}
+	critical := Threshold{Time: time.Second, Loss: 60, HasLoss: true}
+	stats := func(results ...PingResult) *Stats {
+		s := NewStats()
+		for _, res := range results {
+			s.Add(res)
+		}
+		return s
+	}
+	ok := PingResult{Status: StatusOK, Duration: 100 * time.Millisecond}
+	slow := PingResult{Status: StatusOK, Duration: 500 * time.Millisecond}
+	failed := PingResult{Status: StatusErr, Msg: "error connecting"}
+	tests := map[string]struct {
+		stats           *Stats
+		expectedState   int
+		expectedSummary string
+	}{
+		"ok": {
+			stats:           stats(ok, ok, ok, ok, ok),
+			expectedState:   NagiosOK,
+			expectedSummary: "avg 100ms, loss 0%",
+		},
+		"slow": {
+			stats:           stats(ok, slow, slow, ok, slow),
+			expectedState:   NagiosWarning,
+			expectedSummary: "avg 340ms, loss 0%",
+		},
+		"loss": {
+			stats:           stats(ok, failed, failed, failed, ok),
+			expectedState:   NagiosCritical,
+			expectedSummary: "avg 100ms, loss 60%",
+		},
+		"nothing received": {
+			stats:           stats(failed),
+			expectedState:   NagiosCritical,
+			expectedSummary: "no pings received (error connecting)",
+		},
+		"warn status": {
+			stats:           stats(ok, PingResult{Status: StatusWarn, Duration: 100 * time.Millisecond, Msg: "replication lag 15s over 10s"}),
+			expectedState:   NagiosWarning,
+			expectedSummary: "avg 100ms, loss 0% (replication lag 15s over 10s)",
+		},
+	}
+	for desc, tc := range tests {
+		check := checkNagios("", tc.stats, warning, critical)
+		assert.Equal(t, tc.expectedState, check.State, desc)
+		assert.Equal(t, tc.expectedSummary, check.Summary, desc)
+	}
+
+	check := checkNagios("", stats(ok, ok, ok, ok, failed), Threshold{Time: 200 * time.Millisecond}, Threshold{Time: time.Second})
+	assert.Equal(t, NagiosCritical, check.State, "loss without a loss threshold")
+	check = checkNagios("", stats(ok, ok, ok, ok, failed), warning, Threshold{Time: time.Second})
+	assert.Equal(t, NagiosWarning, check.State, "loss under a warning threshold alone")
+}
+
+func TestNagiosReport(t *testing.T) {
+	s := NewStats()
+	s.Add(PingResult{Status: StatusOK, Duration: 3200 * time.Microsecond})
+	check := checkNagios("", s, Threshold{Time: 200 * time.Millisecond, Loss: 20, HasLoss: true}, Threshold{Time: time.Second})
+	line, state := nagiosReport([]nagiosCheck{check})
+	assert.Equal(t, NagiosOK, state)
+	assert.Equal(t, "PGPING OK - avg 3.2ms, loss 0%|time=0.003200s;0.200000;1.000000;0 loss=0%;20;;0;100", line)
+
+	other := checkNagios("db2", NewStats(), Threshold{}, Threshold{})
+	line, state = nagiosReport([]nagiosCheck{check, other})
+	assert.Equal(t, NagiosCritical, state)
+	assert.Equal(t, "PGPING CRITICAL - avg 3.2ms, loss 0%, db2: no pings received|time=0.003200s;0.200000;1.000000;0 loss=0%;20;;0;100 'db2_time'=0.000000s;;;0 'db2_loss'=0%;;;0;100", line) //nolint:lll
+}
+
+func TestNagiosArgs(t *testing.T) {
+	tests := map[string]struct {
+		args     []string
+		expected []string
+	}{
+		"critical threshold": {
+			args:     []string{"--nagios", "-w", "200ms,20%", "-c", "1s,60%", "db"},
+			expected: []string{"--nagios", "-w", "200ms,20%", "--critical=1s,60%", "db"},
+		},
+		"attached critical threshold": {
+			args:     []string{"--nagios", "-c1s", "db"},
+			expected: []string{"--nagios", "--critical=1s", "db"},
+		},
+		"count": {
+			args:     []string{"--nagios", "-c", "5", "-c10", "db"},
+			expected: []string{"--nagios", "-c", "5", "-c10", "db"},
+		},
+		"after --": {
+			args:     []string{"--nagios", "--", "-c", "1s"},
+			expected: []string{"--nagios", "--", "-c", "1s"},
+		},
+		"trailing -c": {
+			args:     []string{"--nagios", "-c"},
+			expected: []string{"--nagios", "-c"},
+		},
+	}
+	for desc, tc := range tests {
+		assert.Equal(t, tc.expected, nagiosArgs(tc.args), desc)
+	}
+}
+
+func TestNagiosMode(t *testing.T) {
+	tests := map[string]struct {
+		args           []string
+		expectedArgs   []string
+		expectedNagios bool
+		expectedErr    string
+	}{
+		"flag": {
+			args:           []string{"--nagios", "-c", "1s", "db"},
+			expectedArgs:   []string{"--nagios", "-c", "1s", "db"},
+			expectedNagios: true,
+		},
+		"not nagios": {
+			args:         []string{"-c", "1s,60%", "db"},
+			expectedArgs: []string{"-c", "1s,60%", "db"},
+		},
+		"value": {
+			args:           []string{"--nagios=true", "-c", "1s", "db"},
+			expectedArgs:   []string{"--nagios", "-c", "1s", "db"},
+			expectedNagios: true,
+		},
+		"false value": {
+			args:         []string{"--nagios=0", "db"},
+			expectedArgs: []string{"--no-nagios", "db"},
+		},
+		"last wins": {
+			args:         []string{"--nagios", "--no-nagios", "db"},
+			expectedArgs: []string{"--nagios", "--no-nagios", "db"},
+		},
+		"after --": {
+			args:         []string{"--", "--nagios"},
+			expectedArgs: []string{"--", "--nagios"},
+		},
+		"invalid value": {
+			args:           []string{"--nagios=yes"},
+			expectedNagios: true,
+			expectedErr:    "invalid value `yes` for --nagios",
+		},
+	}
+	for desc, tc := range tests {
+		args, nagios, err := nagiosMode(tc.args)
+		if tc.expectedErr != "" {
+			assert.EqualError(t, err, tc.expectedErr, desc)
+		} else {
+			assert.NoError(t, err, desc)
+		}
+		assert.Equal(t, tc.expectedArgs, args, desc)
+		assert.Equal(t, tc.expectedNagios, nagios, desc)
+	}
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	EventOutageStarted = "outage_started"
+	EventOutageEnded   = "outage_ended"
+)
+
+// Outage is a run of consecutive failed pings. End is the time of the first
+// ping that got through again and is zero while the outage is ongoing.
+type Outage struct {
+	Start           time.Time
+	End             time.Time
+	Failed          int
+	FirstError      string
+	FirstErrorClass string
+}
+
+func (o Outage) Ongoing() bool {
+	return o.End.IsZero()
+}
+
+// Duration is how long the outage lasted, or has lasted by now if it is
+// still ongoing.
+func (o Outage) Duration(now time.Time) time.Duration {
+	if o.Ongoing() {
+		return now.Sub(o.Start)
+	}
+	return o.End.Sub(o.Start)
+}
+
+// Event describes the outage starting or ending for the results output.
+func (o Outage) Event(name string, res PingResult) Event {
+	attrs := []EventAttr{{"start", o.Start}}
+	if name == EventOutageEnded {
+		attrs = append(attrs,
+			EventAttr{"end", o.End},
+			EventAttr{"outage", o.Duration(o.End)},
+			EventAttr{"failed", o.Failed},
+		)
+	}
+	attrs = append(attrs,
+		EventAttr{"first_error", o.FirstError},
+		EventAttr{"error_class", o.FirstErrorClass},
+		EventAttr{"i", res.Iteration},
+	)
+	return Event{
+		Time:   res.Time,
+		Target: res.Target,
+		Name:   name,
+		Attrs:  attrs,
+	}
+}
+
+// Outages records every outage seen by a pinger. The last one may still be
+// ongoing.
+type Outages struct {
+	List []Outage
+}
+
+// Add updates the outages with res and returns EventOutageStarted or
+// EventOutageEnded, along with the outage, when res starts or ends one.
+func (o *Outages) Add(res PingResult) (string, Outage) {
+	current := o.current()
+	if res.Pass() {
+		if current == nil {
+			return "", Outage{}
+		}
+		current.End = res.Time
+		return EventOutageEnded, *current
+	}
+	if current != nil {
+		current.Failed++
+		return "", *current
+	}
+	firstError := res.Msg
+	if res.Err != nil {
+		firstError = res.Err.Error()
+	}
+	o.List = append(o.List, Outage{
+		Start:           res.Time,
+		Failed:          1,
+		FirstError:      firstError,
+		FirstErrorClass: res.ErrorClass(),
+	})
+	return EventOutageStarted, o.List[len(o.List)-1]
+}
+
+func (o *Outages) current() *Outage {
+	if len(o.List) == 0 || !o.List[len(o.List)-1].Ongoing() {
+		return nil
+	}
+	return &o.List[len(o.List)-1]
+}
+
+// Downtime adds up how long every outage lasted as of now.
+func (o *Outages) Downtime(now time.Time) time.Duration {
+	var total time.Duration
+	for _, outage := range o.List {
+		total += outage.Duration(now)
+	}
+	return total
+}
+
+// Summary lists the outages for the closing statistics.
+func (o *Outages) Summary(now time.Time) []string {
+	if len(o.List) == 0 {
+		return nil
+	}
+	lines := []string{
+		fmt.Sprintf("%d outages, total downtime %s", len(o.List), o.Downtime(now).Round(time.Millisecond)),
+	}
+	for i, outage := range o.List {
+		line := fmt.Sprintf("outage %d: %s", i+1, kv("start", outage.Start))
+		if outage.Ongoing() {
+			line += " " + kv("ongoing", true)
+		} else {
+			line += " " + kv("end", outage.End)
+		}
+		line += fmt.Sprintf(
+			" %s %s %s",
+			kv("duration", outage.Duration(now).Round(time.Millisecond)),
+			kv("failed", outage.Failed),
+			kv("first_error", outage.FirstError),
+		)
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+type outageJSON struct {
+	Start      time.Time  `json:"start"`
+	End        *time.Time `json:"end,omitempty"`
+	Ongoing    bool       `json:"ongoing,omitempty"`
+	DurationNs int64      `json:"duration_ns"`
+	Failed     int        `json:"failed"`
+	FirstError string     `json:"first_error"`
+	ErrorClass string     `json:"error_class,omitempty"`
+}
+
+func (o *Outages) JSON(now time.Time) []outageJSON {
+	out := make([]outageJSON, 0, len(o.List))
+	for _, outage := range o.List {
+		entry := outageJSON{
+			Start:      outage.Start.UTC(),
+			Ongoing:    outage.Ongoing(),
+			DurationNs: outage.Duration(now).Nanoseconds(),
+			Failed:     outage.Failed,
+			FirstError: outage.FirstError,
+			ErrorClass: outage.FirstErrorClass,
+		}
+		if !outage.Ongoing() {
+			end := outage.End.UTC()
+			entry.End = &end
+		}
+		out = append(out, entry)
+	}
+	return out
+}
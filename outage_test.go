@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOutages(t *testing.T) {
+	start := time.Date(2023, 3, 30, 15, 41, 14, 0, time.UTC)
+	at := func(seconds int) time.Time {
+		return start.Add(time.Duration(seconds) * time.Second)
+	}
+	refused := fmt.Errorf("dial error: %w", syscall.ECONNREFUSED)
+
+	var outages Outages
+	steps := []struct {
+		res      PingResult
+		expected string
+	}{
+		{PingResult{Time: at(0), Status: StatusOK}, ""},
+		{PingResult{Time: at(1), Status: StatusErr, Msg: "error connecting", Err: refused}, EventOutageStarted},
+		{PingResult{Time: at(2), Status: StatusErr, Msg: "error connecting", Err: refused}, ""},
+		{PingResult{Time: at(3), Status: StatusFail, Msg: "0 rows returned"}, ""},
+		{PingResult{Time: at(4), Status: StatusWarn}, EventOutageEnded},
+		{PingResult{Time: at(5), Status: StatusOK}, ""},
+		{PingResult{Time: at(6), Status: StatusFail, Msg: "0 rows returned"}, EventOutageStarted},
+	}
+	for i, step := range steps {
+		name, _ := outages.Add(step.res)
+		assert.Equal(t, step.expected, name, "step %d", i)
+	}
+
+	assert.Equal(t, []Outage{
+		{Start: at(1), End: at(4), Failed: 3, FirstError: "dial error: connection refused", FirstErrorClass: "connection_refused"},
+		{Start: at(6), Failed: 1, FirstError: "0 rows returned"},
+	}, outages.List)
+	assert.Equal(t, 5*time.Second, outages.Downtime(at(8)))
+	assert.Equal(t, []string{
+		"2 outages, total downtime 5s",
+		`outage 1: start=2023-03-30T15:41:15Z end=2023-03-30T15:41:18Z duration=3s failed=3 first_error="dial error: connection refused"`,
+		`outage 2: start=2023-03-30T15:41:20Z ongoing=true duration=2s failed=1 first_error="0 rows returned"`,
+	}, outages.Summary(at(8)))
+
+	out := outages.JSON(at(8))
+	assert.Len(t, out, 2)
+	assert.Equal(t, int64(3*time.Second), out[0].DurationNs)
+	assert.False(t, out[0].Ongoing)
+	assert.Nil(t, out[1].End)
+	assert.True(t, out[1].Ongoing)
+}
+
+func TestOutageEvent(t *testing.T) {
+	start := time.Date(2023, 3, 30, 15, 41, 14, 0, time.UTC)
+	outage := Outage{
+		Start:           start,
+		End:             start.Add(3 * time.Second),
+		Failed:          3,
+		FirstError:      "dial error: connection refused",
+		FirstErrorClass: "connection_refused",
+	}
+	event := outage.Event(EventOutageEnded, PingResult{Time: outage.End, Target: "db", Iteration: 5})
+	assert.Equal(
+		t,
+		`2023-03-30T15:41:17Z     target="db" event="outage_ended" start=2023-03-30T15:41:14Z end=2023-03-30T15:41:17Z outage=3s failed=3 first_error="dial error: connection refused" error_class="connection_refused" i=5`, //nolint:lll
+		event.Text(),
+	)
+}
+
+func TestNoOutages(t *testing.T) {
+	var outages Outages
+	assert.Empty(t, outages.Summary(time.Now()))
+	assert.Empty(t, outages.JSON(time.Now()))
+	assert.Zero(t, outages.Downtime(time.Now()))
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+type Phase string
+
+const (
+	PhaseResolve Phase = "resolve"
+	PhaseConnect Phase = "connect"
+	PhaseTLS     Phase = "tls"
+	PhaseAuth    Phase = "auth"
+	PhaseAcquire Phase = "acquire"
+	PhaseRole    Phase = "role"
+	PhaseCheck   Phase = "check"
+	PhaseWrite   Phase = "write"
+	PhaseCommit  Phase = "commit"
+	PhaseRead    Phase = "read"
+	PhaseQuery   Phase = "query"
+	PhaseClose   Phase = "close"
+)
+
+// Phases lists every phase in the order they happen during a ping.
+var Phases = []Phase{
+	PhaseResolve,
+	PhaseConnect,
+	PhaseTLS,
+	PhaseAuth,
+	PhaseAcquire,
+	PhaseRole,
+	PhaseCheck,
+	PhaseWrite,
+	PhaseCommit,
+	PhaseRead,
+	PhaseQuery,
+	PhaseClose,
+}
+
+// Timings records how long each phase of a single ping took. A phase that
+// happens more than once (e.g. dialing several fallback hosts, or running
+// several queries) accumulates.
+//
+// It also remembers which host name each resolved address came from so that
+// the server that ends up accepting the connection can be reported by name.
+type Timings struct {
+	mu        sync.Mutex
+	durations map[Phase]time.Duration
+	hostnames map[string]string
+}
+
+func NewTimings() *Timings {
+	return &Timings{
+		durations: make(map[Phase]time.Duration),
+		hostnames: make(map[string]string),
+	}
+}
+
+func (t *Timings) resolved(host string, addrs []string) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, addr := range addrs {
+		t.hostnames[addr] = host
+	}
+}
+
+// Server names the host:port that addr, the remote address of a connection,
+// was resolved from.
+func (t *Timings) Server(addr net.Addr) string {
+	if addr == nil {
+		return ""
+	}
+	ip, port, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+	if t != nil {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		if host, ok := t.hostnames[ip]; ok {
+			return net.JoinHostPort(host, port)
+		}
+	}
+	return addr.String()
+}
+
+// Add attributes d to phase.
+func (t *Timings) Add(phase Phase, d time.Duration) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.durations[phase] += d
+}
+
+// network is the time spent on the network phases of connecting so far.
+func (t *Timings) network() time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.durations[PhaseResolve] + t.durations[PhaseConnect] + t.durations[PhaseTLS]
+}
+
+// Durations returns a copy of the recorded phase durations.
+func (t *Timings) Durations() map[Phase]time.Duration {
+	durations := make(map[Phase]time.Duration)
+	if t == nil {
+		return durations
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for phase, d := range t.durations {
+		durations[phase] = d
+	}
+	return durations
+}
+
+type timingsKey struct{}
+
+func WithTimings(ctx context.Context, t *Timings) context.Context {
+	return context.WithValue(ctx, timingsKey{}, t)
+}
+
+func TimingsFromContext(ctx context.Context) *Timings {
+	t, _ := ctx.Value(timingsKey{}).(*Timings)
+	return t
+}
+
+type phaseKey struct{}
+
+type tracedPhase struct {
+	timings *Timings
+	phase   Phase
+}
+
+// withPhase has the queries run under ctx timed as phase in timings.
+func withPhase(ctx context.Context, timings *Timings, phase Phase) context.Context {
+	return context.WithValue(ctx, phaseKey{}, tracedPhase{timings: timings, phase: phase})
+}
+
+type traceStartKey struct{}
+
+// traceStart is when a traced operation started, along with the network time
+// recorded by then for connects.
+type traceStart struct {
+	at      time.Time
+	network time.Duration
+}
+
+// phaseTracer is the pgx tracer that times connecting and queries. Connects
+// are timed for the Timings carried by their context, queries as the phase
+// carried by theirs; anything else isn't timed.
+type phaseTracer struct{}
+
+func (phaseTracer) TraceConnectStart(ctx context.Context, data pgx.TraceConnectStartData) context.Context {
+	timings := TimingsFromContext(ctx)
+	if timings == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, traceStartKey{}, traceStart{at: time.Now(), network: timings.network()})
+}
+
+func (phaseTracer) TraceConnectEnd(ctx context.Context, data pgx.TraceConnectEndData) {
+	start, ok := ctx.Value(traceStartKey{}).(traceStart)
+	if !ok {
+		return
+	}
+	// what connecting took beyond resolving, dialing and TLS handshakes went
+	// into the startup exchange and authentication
+	timings := TimingsFromContext(ctx)
+	timings.Add(PhaseAuth, time.Since(start.at)-(timings.network()-start.network))
+}
+
+func (phaseTracer) TraceQueryStart(ctx context.Context, conn *pgx.Conn, data pgx.TraceQueryStartData) context.Context {
+	if _, ok := ctx.Value(phaseKey{}).(tracedPhase); !ok {
+		return ctx
+	}
+	return context.WithValue(ctx, traceStartKey{}, traceStart{at: time.Now()})
+}
+
+func (phaseTracer) TraceQueryEnd(ctx context.Context, conn *pgx.Conn, data pgx.TraceQueryEndData) {
+	traced, ok := ctx.Value(phaseKey{}).(tracedPhase)
+	start, started := ctx.Value(traceStartKey{}).(traceStart)
+	if !ok || !started {
+		return
+	}
+	traced.timings.Add(traced.phase, time.Since(start.at))
+}
+
+// instrumentConnConfig times each phase of connection setup for the Timings
+// carried by the connect context: resolving, dialing and the TLS handshake
+// through the resolver, dialer and post-connect hooks, as pgx has no tracer
+// for them, and the rest through phaseTracer, which also times queries.
+func instrumentConnConfig(connConfig *pgx.ConnConfig) {
+	connConfig.Tracer = phaseTracer{}
+
+	lookup := connConfig.LookupFunc
+	connConfig.LookupFunc = func(ctx context.Context, host string) ([]string, error) {
+		start := time.Now()
+		addrs, err := lookup(ctx, host)
+		timings := TimingsFromContext(ctx)
+		timings.Add(PhaseResolve, time.Since(start))
+		timings.resolved(host, addrs)
+		trace("resolved host", "host", host, "addrs", addrs, "err", err)
+		return addrs, err
+	}
+
+	dial := connConfig.DialFunc
+	connConfig.DialFunc = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		start := time.Now()
+		conn, err := dial(ctx, network, addr)
+		TimingsFromContext(ctx).Add(PhaseConnect, time.Since(start))
+		trace("dialed", "network", network, "addr", addr, "err", err)
+		return conn, err
+	}
+
+	afterNetConnect := connConfig.AfterNetConnect
+	connConfig.AfterNetConnect = func(ctx context.Context, config *pgconn.Config, conn net.Conn) (net.Conn, error) {
+		if tlsConn, ok := conn.(*tls.Conn); ok {
+			// pgconn leaves the handshake to happen lazily on the first write, so
+			// force it here to be able to time it on its own.
+			start := time.Now()
+			err := tlsConn.HandshakeContext(ctx)
+			TimingsFromContext(ctx).Add(PhaseTLS, time.Since(start))
+			trace("completed tls handshake", "addr", conn.RemoteAddr(), "err", err)
+			if err != nil {
+				// pgconn closes the conn it gets back on error
+				return conn, err
+			}
+		}
+		if afterNetConnect != nil {
+			return afterNetConnect(ctx, config, conn)
+		}
+		return conn, nil
+	}
+}
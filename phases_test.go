@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTimings(t *testing.T) {
+	timings := NewTimings()
+	timings.Add(PhaseConnect, time.Millisecond)
+	timings.Add(PhaseQuery, time.Millisecond)
+	timings.Add(PhaseQuery, 2*time.Millisecond)
+
+	durations := timings.Durations()
+	assert.Equal(t, time.Millisecond, durations[PhaseConnect])
+	assert.Equal(t, 3*time.Millisecond, durations[PhaseQuery])
+	assert.NotContains(t, durations, PhaseTLS)
+}
+
+func TestTimingsServer(t *testing.T) {
+	timings := NewTimings()
+	timings.resolved("db.example.com", []string{"192.0.2.1", "2001:db8::1"})
+	assert.Equal(t, "db.example.com:5432", timings.Server(&net.TCPAddr{IP: net.ParseIP("2001:db8::1"), Port: 5432}))
+	assert.Equal(t, "192.0.2.2:5432", timings.Server(&net.TCPAddr{IP: net.ParseIP("192.0.2.2"), Port: 5432}))
+	assert.Equal(t, "", timings.Server(nil))
+}
+
+func TestTimingsNil(t *testing.T) {
+	var timings *Timings
+	timings.Add(PhaseConnect, time.Millisecond)
+	assert.Empty(t, timings.Durations())
+	assert.Nil(t, TimingsFromContext(context.Background()))
+}
+
+func TestInstrumentConnConfig(t *testing.T) {
+	connConfig := ReferenceConnConfig(t)
+	connConfig.LookupFunc = func(ctx context.Context, host string) ([]string, error) {
+		time.Sleep(time.Millisecond)
+		return []string{"127.0.0.1"}, nil
+	}
+	instrumentConnConfig(connConfig)
+
+	timings := NewTimings()
+	addrs, err := connConfig.LookupFunc(WithTimings(context.Background(), timings), "db.example.com")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"127.0.0.1"}, addrs)
+	assert.GreaterOrEqual(t, timings.Durations()[PhaseResolve], time.Millisecond)
+}
+
+func TestPhaseTracer(t *testing.T) {
+	server := newFakeServer(t)
+	connConfig := server.connConfig(t)
+	dialDelay := 20 * time.Millisecond
+	connConfig.DialFunc = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		time.Sleep(dialDelay)
+		var d net.Dialer
+		return d.DialContext(ctx, network, addr)
+	}
+	instrumentConnConfig(connConfig)
+
+	timings := NewTimings()
+	ctx := WithTimings(context.Background(), timings)
+	conn, err := pgx.ConnectConfig(ctx, connConfig)
+	if err != nil {
+		t.Fatalf("error %v", err)
+	}
+	defer conn.Close(context.Background())
+	_, err = conn.Exec(withPhase(ctx, timings, PhaseQuery), "SELECT 1")
+	assert.NoError(t, err)
+	// queries without a phase aren't timed
+	_, err = conn.Exec(ctx, "SELECT 1")
+	assert.NoError(t, err)
+
+	durations := timings.Durations()
+	assert.Contains(t, durations, PhaseResolve)
+	assert.GreaterOrEqual(t, durations[PhaseConnect], dialDelay)
+	assert.Less(t, durations[PhaseAuth], dialDelay, "dialing counted as auth")
+	assert.Positive(t, durations[PhaseAuth])
+	assert.Positive(t, durations[PhaseQuery])
+	assert.Len(t, durations, 4)
+}
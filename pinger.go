@@ -0,0 +1,437 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Pinger pings a single target. By default every ping opens a fresh
+// connection; in persistent mode one connection is held open across pings
+// and re-established whenever it breaks; in pool mode every ping acquires a
+// connection from a pgxpool.Pool and releases it again.
+type Pinger struct {
+	// Label is added to every result line to tell targets apart. It's left
+	// empty when there is only one target.
+	Label      string
+	ConnConfig *pgx.ConnConfig
+	Persistent bool
+	// Pool turns on pool mode when set.
+	Pool      *PoolSettings
+	CheckRole bool
+	// Wait is the time between the start of one ping and the next.
+	Wait time.Duration
+	// Timeout bounds each ping.
+	Timeout time.Duration
+	// Timeouts bound the phases of each ping.
+	Timeouts Timeouts
+	// Check is the kind of check run on top of the query, if any.
+	Check                 string
+	ReplicationThresholds ReplicationThresholds
+	CertThresholds        CertThresholds
+	Heartbeat             Heartbeat
+	// Queries run in order on every ping.
+	Queries []Query
+	Stats   *Stats
+	Metrics *Metrics
+	// Quiet stops results from being printed, for floods which would print
+	// thousands a second.
+	Quiet bool
+
+	conn       *pgx.Conn
+	pool       *pgxpool.Pool
+	connServer string
+	connTLS    *TLSInfo
+	connects   int
+	lastServer string
+	lastRole   roleSighting
+	// heartbeatReady is set once the heartbeat table is known to exist.
+	heartbeatReady bool
+	// onHeartbeat is called as soon as a heartbeat is committed.
+	onHeartbeat func(heartbeatWrite)
+}
+
+func NewPinger(label string, connConfig *pgx.ConnConfig) *Pinger {
+	p := &Pinger{
+		Label:      label,
+		ConnConfig: connConfig,
+		Persistent: *persistent,
+		CheckRole:  *checkRole,
+		Wait:       *wait,
+		Timeout:    *timeout,
+		Check:      *check,
+		Timeouts: Timeouts{
+			Connect: *connectTimeout,
+			Query:   *queryTimeout,
+			Close:   *closeTimeout,
+		},
+		ReplicationThresholds: ReplicationThresholds{
+			WarnLag:      *replWarnLag,
+			CritLag:      *replCritLag,
+			WarnLagBytes: int64(*replWarnBytes),
+			CritLagBytes: int64(*replCritBytes),
+		},
+		CertThresholds: CertThresholds{
+			WarnDays: *certWarnDays,
+			CritDays: *certCritDays,
+		},
+		Heartbeat: Heartbeat{
+			Table:  *heartbeatTable,
+			Client: *heartbeatClient,
+			Create: *heartbeatCreate,
+		},
+		Stats: NewStats(),
+	}
+	if *pool {
+		p.Pool = &PoolSettings{
+			MinConns:          int32(*poolMinConns),
+			MaxConns:          int32(*poolMaxConns),
+			HealthCheckPeriod: *poolHealthCheckPeriod,
+			MaxConnLifetime:   *poolMaxConnLifetime,
+		}
+	}
+	return p
+}
+
+// Name identifies the target in the summary statistics.
+func (p *Pinger) Name() string {
+	if p.Label != "" {
+		return p.Label
+	}
+	return p.host()
+}
+
+// host is the configured host, or every host:port tried for a multi-host
+// target.
+func (p *Pinger) host() string {
+	if servers := connConfigServers(p.ConnConfig); len(servers) > 1 {
+		return strings.Join(servers, ",")
+	}
+	return p.ConnConfig.Host
+}
+
+// Run pings on its own schedule until count is reached or ctx is cancelled
+// and reports whether the last ping failed.
+func (p *Pinger) Run(ctx context.Context) bool {
+	failed := false
+	for i := 1; *count == -1 || i <= *count; i++ {
+		res := p.Ping(ctx, i)
+		if ctx.Err() != nil {
+			// interrupted mid-ping, so this one doesn't count
+			break
+		}
+		p.observe(res)
+		failed = !res.Pass()
+		if i == *count {
+			break
+		}
+		timeUntilNext := p.Wait - res.Duration
+		if timeUntilNext > 0 {
+			select {
+			case <-ctx.Done():
+			case <-time.After(timeUntilNext):
+			}
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+
+	p.closeHeld()
+	return failed
+}
+
+// observe takes a ping into account once it's done.
+func (p *Pinger) observe(res PingResult) {
+	p.Stats.Add(res)
+	p.Metrics.Observe(p.Name(), res)
+	p.checkServer(res)
+	p.checkRole(res)
+	p.checkOutage(res)
+}
+
+// closeHeld closes the held connection once pinging is over.
+func (p *Pinger) closeHeld() {
+	timeout := p.Timeout
+	if p.Timeouts.Close > 0 {
+		timeout = p.Timeouts.Close
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	err := p.Close(ctx)
+	if err != nil {
+		logger.Error("error closing connection", "target", p.Name(), "err", err)
+	}
+}
+
+// checkServer prints an event whenever a different server than last time
+// accepted the connection, e.g. because a multi-host target failed over.
+func (p *Pinger) checkServer(res PingResult) {
+	if res.Server == "" {
+		return
+	}
+	if p.lastServer != "" && res.Server != p.lastServer {
+		printEvent(Event{
+			Time:   res.Time,
+			Target: p.Label,
+			Name:   "server_changed",
+			Attrs: []EventAttr{
+				{"from", p.lastServer},
+				{"to", res.Server},
+				{"i", res.Iteration},
+			},
+		})
+	}
+	p.lastServer = res.Server
+}
+
+// checkRole prints an event whenever the server is promoted or demoted or
+// moves to a new timeline.
+func (p *Pinger) checkRole(res PingResult) {
+	if res.Role == "" {
+		return
+	}
+	if event, ok := roleChange(p.lastRole, res); ok {
+		printEvent(event)
+	}
+	p.lastRole = roleSighting{Role: res.Role, Timeline: res.Timeline, Time: res.Time}
+}
+
+// checkOutage tracks runs of failed pings and prints an event when one
+// starts or ends.
+func (p *Pinger) checkOutage(res PingResult) {
+	name, outage := p.Stats.Outages.Add(res)
+	if name != "" {
+		printEvent(outage.Event(name, res))
+	}
+}
+
+func (p *Pinger) Ping(ctx context.Context, i int) PingResult {
+	if p.pool != nil {
+		return p.pingPool(ctx, i)
+	}
+	if !p.Persistent {
+		return p.ping(ctx, i)
+	}
+	return p.pingPersistent(ctx, i)
+}
+
+// Close closes the held connection and the pool, if any.
+func (p *Pinger) Close(ctx context.Context) error {
+	if p.pool != nil {
+		p.pool.Close()
+		p.pool = nil
+	}
+	if p.conn == nil {
+		return nil
+	}
+	err := p.conn.Close(ctx)
+	p.conn = nil
+	return err
+}
+
+// result fills in the details common to every result from this pinger,
+// prints it and hands it back.
+func (p *Pinger) result(i int, start time.Time, timings *Timings, res PingResult) PingResult {
+	res.Time = time.Now()
+	res.Iteration = i
+	res.Target = p.Label
+	res.Host = p.host()
+	res.Port = p.ConnConfig.Port
+	res.Database = p.ConnConfig.Database
+	res.Duration = time.Since(start)
+	res.Phases = timings.Durations()
+	if !p.Quiet {
+		printResult(res)
+	}
+	return res
+}
+
+func (p *Pinger) ping(parent context.Context, i int) PingResult {
+	ctx, cancel := withTimeout(parent, "timeout", p.Timeout)
+	defer cancel()
+	timings := NewTimings()
+	ctx = WithTimings(ctx, timings)
+	start := time.Now()
+	connectCtx, cancelConnect := withTimeout(ctx, "connect-timeout", p.Timeouts.Connect)
+	defer cancelConnect()
+	conn, err := pgx.ConnectConfig(connectCtx, p.ConnConfig)
+	if err != nil {
+		return p.result(i, start, timings, PingResult{Status: StatusErr, Msg: "error connecting", Err: err, Deadline: p.deadlineHit(connectCtx, err)})
+	}
+	res := PingResult{
+		Server: timings.Server(conn.PgConn().Conn().RemoteAddr()),
+		TLS:    tlsInfo(conn.PgConn().Conn()),
+	}
+	queryCtx, cancelQuery := withTimeout(ctx, "query-timeout", p.Timeouts.Query)
+	defer cancelQuery()
+	if !p.inspect(queryCtx, conn, timings, i, &res) {
+		res.Deadline = p.deadlineHit(queryCtx, res.Err)
+		conn.Close(ctx)
+		return p.result(i, start, timings, res)
+	}
+	worst := p.query(withPhase(queryCtx, timings, PhaseQuery), conn, &res)
+	if worst.Status == StatusErr {
+		conn.Close(ctx)
+		res.Status, res.Msg, res.Err = worst.Status, worst.Msg, worst.Err
+		res.Deadline = p.deadlineHit(queryCtx, res.Err)
+		return p.result(i, start, timings, res)
+	}
+	closeCtx, cancelClose := withTimeout(ctx, "close-timeout", p.Timeouts.Close)
+	defer cancelClose()
+	closeStart := time.Now()
+	err = conn.Close(closeCtx)
+	timings.Add(PhaseClose, time.Since(closeStart))
+	if err != nil {
+		res.Status, res.Msg, res.Err = StatusErr, "error closing", err
+		res.Deadline = p.deadlineHit(closeCtx, err)
+		return p.result(i, start, timings, res)
+	}
+	if worst.Status == StatusFail {
+		res.Status, res.Msg = worst.Status, worst.Msg
+		return p.result(i, start, timings, res)
+	}
+	res.Status, res.Msg = p.status(res)
+	return p.result(i, start, timings, res)
+}
+
+// query runs every query on conn in order and returns the outcome of the
+// worst one, with its message prefixed by the query name when there are
+// several. Their individual outcomes are only added to res in that case, so
+// the output of a single query stays as it always was.
+func (p *Pinger) query(ctx context.Context, conn *pgx.Conn, res *PingResult) QueryStatus {
+	worst := QueryStatus{Status: StatusOK}
+	for _, q := range p.Queries {
+		status := runQuery(ctx, conn, q)
+		if len(p.Queries) > 1 {
+			res.Queries = append(res.Queries, status)
+			if status.Msg != "" {
+				status.Msg = q.Name + ": " + status.Msg
+			}
+		}
+		if statusSeverity[status.Status] > statusSeverity[worst.Status] {
+			worst = status
+		}
+	}
+	return worst
+}
+
+func (p *Pinger) pingPersistent(parent context.Context, i int) PingResult {
+	ctx, cancel := withTimeout(parent, "timeout", p.Timeout)
+	defer cancel()
+	if p.conn == nil || p.conn.IsClosed() {
+		res := p.connect(ctx, i)
+		if !res.Pass() {
+			return res
+		}
+	}
+	timings := NewTimings()
+	start := time.Now()
+	res := PingResult{Server: p.connServer, TLS: p.connTLS}
+	queryCtx, cancelQuery := withTimeout(ctx, "query-timeout", p.Timeouts.Query)
+	defer cancelQuery()
+	if !p.inspect(queryCtx, p.conn, timings, i, &res) {
+		res.Deadline = p.deadlineHit(queryCtx, res.Err)
+		return p.result(i, start, timings, res)
+	}
+	worst := p.query(withPhase(queryCtx, timings, PhaseQuery), p.conn, &res)
+	if worst.Status == StatusErr {
+		res.Status, res.Msg, res.Err = worst.Status, worst.Msg, worst.Err
+		res.Deadline = p.deadlineHit(queryCtx, res.Err)
+		return p.result(i, start, timings, res)
+	}
+	if worst.Status == StatusFail {
+		res.Status, res.Msg = worst.Status, worst.Msg
+		return p.result(i, start, timings, res)
+	}
+	res.Status, res.Msg = p.status(res)
+	return p.result(i, start, timings, res)
+}
+
+// inspect runs the checks beyond the query that are enabled for this pinger
+// against conn as part of ping i and adds what they find to res. If one of
+// them fails it marks res as failed and returns false.
+func (p *Pinger) inspect(ctx context.Context, conn *pgx.Conn, timings *Timings, i int, res *PingResult) bool {
+	var err error
+	if p.CheckRole {
+		res.Role, res.Timeline, err = queryRole(withPhase(ctx, timings, PhaseRole), conn)
+		if err != nil {
+			res.Status, res.Msg, res.Err = StatusErr, "error checking role", err
+			return false
+		}
+	}
+	if p.Check == CheckReplication {
+		res.Replication, err = queryReplication(withPhase(ctx, timings, PhaseCheck), conn)
+		if err != nil {
+			res.Status, res.Msg, res.Err = StatusErr, "error checking replication", err
+			return false
+		}
+		if res.Role == "" {
+			res.Role = res.Replication.Role
+		}
+	}
+	if p.Check == CheckWrite {
+		if p.Heartbeat.Create && !p.heartbeatReady {
+			_, err = conn.Exec(withPhase(ctx, timings, PhaseWrite), p.Heartbeat.createQuery())
+			if err != nil {
+				res.Status, res.Msg, res.Err = StatusErr, "error creating heartbeat table", err
+				return false
+			}
+			p.heartbeatReady = true
+		}
+		_, failure, err := p.Heartbeat.write(ctx, conn, timings, i, p.onHeartbeat)
+		if err != nil {
+			res.Status, res.Msg, res.Err = StatusErr, "error writing heartbeat", err
+			return false
+		}
+		if failure != "" {
+			res.Status, res.Msg = StatusFail, failure
+			return false
+		}
+	}
+	return true
+}
+
+// status decides the status of a ping whose query succeeded.
+// The worst of the checks that ran wins.
+func (p *Pinger) status(res PingResult) (string, string) {
+	status, msg := StatusOK, ""
+	worse := func(s, m string) {
+		if statusSeverity[s] > statusSeverity[status] {
+			status, msg = s, m
+		}
+	}
+	if res.TLS != nil {
+		worse(res.TLS.Status(p.CertThresholds, time.Now()))
+	}
+	if res.Replication != nil {
+		worse(res.Replication.Status(p.ReplicationThresholds))
+	}
+	return status, msg
+}
+
+// connect (re-)establishes the held connection and logs it as its own result
+// line so that reconnects and the time they took stand out from query pings.
+func (p *Pinger) connect(ctx context.Context, i int) PingResult {
+	event := "connect"
+	if p.connects > 0 {
+		event = "reconnect"
+	}
+	logger.Debug("connecting", "target", p.Name(), "event", event)
+	ctx, cancel := withTimeout(ctx, "connect-timeout", p.Timeouts.Connect)
+	defer cancel()
+	timings := NewTimings()
+	start := time.Now()
+	conn, err := pgx.ConnectConfig(WithTimings(ctx, timings), p.ConnConfig)
+	if err != nil {
+		return p.result(i, start, timings, PingResult{Status: StatusErr, Event: event, Msg: "error connecting", Err: err, Deadline: p.deadlineHit(ctx, err)})
+	}
+	p.conn = conn
+	p.connServer = timings.Server(conn.PgConn().Conn().RemoteAddr())
+	p.connTLS = tlsInfo(conn.PgConn().Conn())
+	p.connects++
+	return p.result(i, start, timings, PingResult{Status: StatusOK, Event: event, Server: p.connServer, TLS: p.connTLS})
+}
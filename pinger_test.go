@@ -0,0 +1,379 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgproto3"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeServer speaks just enough of the protocol for a pinger whose queries
+// go out in QueryExecModeExec. Every query returns a single 1, except for the
+// role check, queries mentioning fail, which fail, and the heartbeat, which
+// it keeps in memory. A replica reads the heartbeat of its
+// primary once it's lag old.
+type fakeServer struct {
+	ln      net.Listener
+	primary *fakeServer
+	lag     time.Duration
+
+	mu        sync.Mutex
+	conns     []net.Conn
+	accepted  int
+	heartbeat []fakeParam
+	written   time.Time
+}
+
+// fakeParam is a parameter value along with its format, so that it can be
+// sent back as it came.
+type fakeParam struct {
+	format int16
+	value  []byte
+}
+
+func newFakeServer(t *testing.T) *fakeServer {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("error %v", err)
+	}
+	s := &fakeServer{ln: ln}
+	go s.serve()
+	t.Cleanup(func() {
+		ln.Close()
+		s.drop()
+	})
+	return s
+}
+
+func (s *fakeServer) connConfig(t *testing.T) *pgx.ConnConfig {
+	connConfig, err := pgx.ParseConfig(fmt.Sprintf("postgres://pgping@%s/postgres?sslmode=disable", s.ln.Addr()))
+	if err != nil {
+		t.Fatalf("error %v", err)
+	}
+	connConfig.DefaultQueryExecMode = pgx.QueryExecModeExec
+	return connConfig
+}
+
+// connections is how many connections the server has accepted.
+func (s *fakeServer) connections() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.accepted
+}
+
+// drop closes every connection, like a server restart would.
+func (s *fakeServer) drop() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, c := range s.conns {
+		c.Close()
+	}
+	s.conns = nil
+}
+
+func (s *fakeServer) serve() {
+	for {
+		c, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		s.conns = append(s.conns, c)
+		s.accepted++
+		s.mu.Unlock()
+		go s.handle(c)
+	}
+}
+
+func (s *fakeServer) readHeartbeat() []fakeParam {
+	if s.primary != nil {
+		s.primary.mu.Lock()
+		defer s.primary.mu.Unlock()
+		if time.Since(s.primary.written) < s.lag {
+			return nil
+		}
+		return s.primary.heartbeat
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.heartbeat
+}
+
+// fakeResult is how the fake answers a query.
+type fakeResult struct {
+	fields []pgproto3.FieldDescription
+	rows   [][]fakeParam
+	err    *pgproto3.ErrorResponse
+}
+
+func (s *fakeServer) result(sql string, params []fakeParam) fakeResult {
+	switch {
+	case strings.HasPrefix(sql, "INSERT"):
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.heartbeat = params[1:3]
+		s.written = time.Now()
+		return fakeResult{}
+	case strings.HasPrefix(sql, "SELECT iteration"):
+		res := fakeResult{fields: []pgproto3.FieldDescription{
+			{Name: []byte("iteration"), DataTypeOID: 20},
+			{Name: []byte("client_time"), DataTypeOID: 1184},
+		}}
+		if heartbeat := s.readHeartbeat(); heartbeat != nil {
+			res.fields[0].Format, res.fields[1].Format = heartbeat[0].format, heartbeat[1].format
+			res.rows = [][]fakeParam{heartbeat}
+		}
+		return res
+	case strings.HasPrefix(sql, "SELECT pg_is_in_recovery()"):
+		return fakeResult{
+			fields: []pgproto3.FieldDescription{
+				{Name: []byte("pg_is_in_recovery"), DataTypeOID: 16},
+				{Name: []byte("timeline"), DataTypeOID: 20},
+			},
+			rows: [][]fakeParam{{{value: []byte("f")}, {value: []byte("3")}}},
+		}
+	case strings.Contains(sql, "fail"):
+		return fakeResult{err: &pgproto3.ErrorResponse{Severity: "ERROR", Code: "42703", Message: `column "fail" does not exist`}}
+	case strings.HasPrefix(sql, "SELECT"):
+		return fakeResult{
+			fields: []pgproto3.FieldDescription{{Name: []byte("?column?"), DataTypeOID: 23}},
+			rows:   [][]fakeParam{{{value: []byte("1")}}},
+		}
+	}
+	return fakeResult{}
+}
+
+// send sends res as the outcome of running sql, with its row description
+// first if describe is set.
+func (res fakeResult) send(be *pgproto3.Backend, sql string, describe bool) {
+	if res.err != nil {
+		be.Send(res.err)
+		return
+	}
+	if describe && res.fields != nil {
+		be.Send(&pgproto3.RowDescription{Fields: res.fields})
+	}
+	for _, row := range res.rows {
+		values := make([][]byte, len(row))
+		for i, p := range row {
+			values[i] = p.value
+		}
+		be.Send(&pgproto3.DataRow{Values: values})
+	}
+	tag := strings.ToUpper(strings.Fields(sql)[0])
+	switch tag {
+	case "SELECT":
+		tag = fmt.Sprintf("SELECT %d", len(res.rows))
+	case "INSERT":
+		tag = "INSERT 0 1"
+	}
+	be.Send(&pgproto3.CommandComplete{CommandTag: []byte(tag)})
+}
+
+func (s *fakeServer) handle(c net.Conn) {
+	defer c.Close()
+	be := pgproto3.NewBackend(c, c)
+	_, err := be.ReceiveStartupMessage()
+	if err != nil {
+		return
+	}
+	be.Send(&pgproto3.AuthenticationOk{})
+	be.Send(&pgproto3.BackendKeyData{ProcessID: 1, SecretKey: []byte{0, 0, 0, 1}})
+	be.Send(&pgproto3.ReadyForQuery{TxStatus: 'I'})
+	if be.Flush() != nil {
+		return
+	}
+	var (
+		sql string
+		res fakeResult
+	)
+	for {
+		msg, err := be.Receive()
+		if err != nil {
+			return
+		}
+		switch m := msg.(type) {
+		case *pgproto3.Query:
+			s.result(m.String, nil).send(be, m.String, true)
+			be.Send(&pgproto3.ReadyForQuery{TxStatus: 'I'})
+			if be.Flush() != nil {
+				return
+			}
+		case *pgproto3.Parse:
+			sql = m.Query
+			be.Send(&pgproto3.ParseComplete{})
+		case *pgproto3.Bind:
+			params := make([]fakeParam, len(m.Parameters))
+			for i, value := range m.Parameters {
+				params[i].value = append([]byte(nil), value...)
+				if len(m.ParameterFormatCodes) == 1 {
+					params[i].format = m.ParameterFormatCodes[0]
+				} else if len(m.ParameterFormatCodes) > i {
+					params[i].format = m.ParameterFormatCodes[i]
+				}
+			}
+			res = s.result(sql, params)
+			be.Send(&pgproto3.BindComplete{})
+		case *pgproto3.Describe:
+			if res.fields != nil {
+				be.Send(&pgproto3.RowDescription{Fields: res.fields})
+			} else {
+				be.Send(&pgproto3.NoData{})
+			}
+		case *pgproto3.Execute:
+			res.send(be, sql, false)
+		case *pgproto3.Sync:
+			be.Send(&pgproto3.ReadyForQuery{TxStatus: 'I'})
+			if be.Flush() != nil {
+				return
+			}
+		case *pgproto3.Terminate:
+			return
+		}
+	}
+}
+
+// captureResults collects the JSON of every result printed until the test
+// ends.
+func captureResults(t *testing.T) func() []map[string]any {
+	var buf bytes.Buffer
+	outputs = []Output{{Format: "json", Writer: &buf}}
+	t.Cleanup(func() {
+		outputs = nil
+	})
+	return func() []map[string]any {
+		var lines []map[string]any
+		for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+			var out map[string]any
+			err := json.Unmarshal([]byte(line), &out)
+			if err != nil {
+				t.Fatalf("error %v", err)
+			}
+			lines = append(lines, out)
+		}
+		buf.Reset()
+		return lines
+	}
+}
+
+func TestPingPersistentReconnects(t *testing.T) {
+	server := newFakeServer(t)
+	results := captureResults(t)
+	connConfig := server.connConfig(t)
+	failDials := 1
+	dialDelay := 100 * time.Millisecond
+	connConfig.DialFunc = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if failDials > 0 {
+			failDials--
+			return nil, errors.New("connection refused")
+		}
+		time.Sleep(dialDelay)
+		var d net.Dialer
+		return d.DialContext(ctx, network, addr)
+	}
+	p := &Pinger{
+		ConnConfig: connConfig,
+		Persistent: true,
+		Timeout:    5 * time.Second,
+		Queries:    []Query{{SQL: "SELECT 1"}},
+	}
+	defer p.closeHeld()
+	ctx := context.Background()
+
+	res := p.Ping(ctx, 1)
+	assert.Equal(t, StatusErr, res.Status)
+	assert.Equal(t, "connect", res.Event)
+
+	res = p.Ping(ctx, 2)
+	assert.Equal(t, StatusOK, res.Status)
+	assert.Empty(t, res.Event)
+	assert.Less(t, res.Duration, dialDelay, "connecting counted towards the ping")
+	lines := results()
+	if assert.Len(t, lines, 3) {
+		assert.Equal(t, "connect", lines[0]["event"])
+		assert.Equal(t, StatusErr, lines[0]["status"])
+		assert.Equal(t, "connect", lines[1]["event"])
+		assert.Equal(t, StatusOK, lines[1]["status"])
+		assert.GreaterOrEqual(t, lines[1]["duration_ns"], float64(dialDelay))
+		assert.Nil(t, lines[2]["event"])
+	}
+
+	server.drop()
+	res = p.Ping(ctx, 3)
+	assert.Equal(t, StatusErr, res.Status)
+	assert.True(t, p.conn.IsClosed())
+
+	res = p.Ping(ctx, 4)
+	assert.Equal(t, StatusOK, res.Status)
+	assert.Less(t, res.Duration, dialDelay, "reconnecting counted towards the ping")
+	assert.Equal(t, 2, p.connects)
+	lines = results()
+	if assert.Len(t, lines, 3) {
+		assert.Equal(t, "reconnect", lines[1]["event"])
+		assert.Equal(t, StatusOK, lines[1]["status"])
+		assert.Equal(t, float64(4), lines[1]["iteration"])
+	}
+}
+
+func TestPingPersistentKeepsConnection(t *testing.T) {
+	server := newFakeServer(t)
+	results := captureResults(t)
+	p := &Pinger{
+		ConnConfig: server.connConfig(t),
+		Persistent: true,
+		Timeout:    5 * time.Second,
+		Queries:    []Query{{SQL: "SELECT 1"}},
+	}
+	defer p.closeHeld()
+	for i := 1; i <= 3; i++ {
+		res := p.Ping(context.Background(), i)
+		assert.Equal(t, StatusOK, res.Status)
+	}
+	assert.Equal(t, 1, p.connects)
+	assert.Len(t, results(), 4)
+}
+
+func TestPingChecksBeforeQueries(t *testing.T) {
+	server := newFakeServer(t)
+	captureResults(t)
+	tests := map[string]struct {
+		persistent bool
+		pool       *PoolSettings
+	}{
+		"new connection": {},
+		"persistent":     {persistent: true},
+		"pool":           {pool: &PoolSettings{MaxConns: 1, HealthCheckPeriod: time.Minute, MaxConnLifetime: time.Hour}},
+	}
+	for desc, tc := range tests {
+		p := &Pinger{
+			ConnConfig: server.connConfig(t),
+			Persistent: tc.persistent,
+			Pool:       tc.pool,
+			CheckRole:  true,
+			Timeout:    5 * time.Second,
+			Queries:    []Query{{SQL: "SELECT fail"}},
+		}
+		if p.Pool != nil {
+			err := p.openPool()
+			if err != nil {
+				t.Fatalf("%s: error %v", desc, err)
+			}
+		}
+		res := p.Ping(context.Background(), 1)
+		p.closeHeld()
+		assert.Equal(t, StatusErr, res.Status, desc)
+		assert.Equal(t, "error querying", res.Msg, desc)
+		assert.Equal(t, RolePrimary, res.Role, desc)
+		assert.Equal(t, int64(3), res.Timeline, desc)
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// PoolSettings configure the pgxpool.Pool a pinger in pool mode goes
+// through, the same knobs services using pgxpool tune.
+type PoolSettings struct {
+	MinConns          int32
+	MaxConns          int32
+	HealthCheckPeriod time.Duration
+	MaxConnLifetime   time.Duration
+}
+
+// PoolStats is a snapshot of a pool's counters taken right after a ping
+// released its connection.
+type PoolStats struct {
+	Total        int32
+	Idle         int32
+	InUse        int32
+	Constructing int32
+	Max          int32
+	// CanceledAcquires counts the acquires since the pool was created that
+	// gave up before getting a connection.
+	CanceledAcquires int64
+}
+
+func poolStats(stat *pgxpool.Stat) *PoolStats {
+	return &PoolStats{
+		Total:            stat.TotalConns(),
+		Idle:             stat.IdleConns(),
+		InUse:            stat.AcquiredConns(),
+		Constructing:     stat.ConstructingConns(),
+		Max:              stat.MaxConns(),
+		CanceledAcquires: stat.CanceledAcquireCount(),
+	}
+}
+
+// kvs renders s for the text output.
+func (s *PoolStats) kvs() []string {
+	return []string{
+		kv("pool_total", s.Total),
+		kv("pool_idle", s.Idle),
+		kv("pool_in_use", s.InUse),
+		kv("pool_constructing", s.Constructing),
+		kv("pool_max", s.Max),
+		kv("pool_canceled_acquires", s.CanceledAcquires),
+	}
+}
+
+type jsonPoolStats struct {
+	Total            int32 `json:"total"`
+	Idle             int32 `json:"idle"`
+	InUse            int32 `json:"in_use"`
+	Constructing     int32 `json:"constructing"`
+	Max              int32 `json:"max"`
+	CanceledAcquires int64 `json:"canceled_acquires"`
+}
+
+func (s *PoolStats) json() *jsonPoolStats {
+	return &jsonPoolStats{
+		Total:            s.Total,
+		Idle:             s.Idle,
+		InUse:            s.InUse,
+		Constructing:     s.Constructing,
+		Max:              s.Max,
+		CanceledAcquires: s.CanceledAcquires,
+	}
+}
+
+// openPool creates the pool of a pinger in pool mode. Connections are only
+// made once pings acquire them, or in the background to keep MinConns.
+func (p *Pinger) openPool() error {
+	config, err := pgxpool.ParseConfig("")
+	if err != nil {
+		return err
+	}
+	config.ConnConfig = p.ConnConfig.Copy()
+	config.MinConns = p.Pool.MinConns
+	config.MaxConns = p.Pool.MaxConns
+	config.HealthCheckPeriod = p.Pool.HealthCheckPeriod
+	config.MaxConnLifetime = p.Pool.MaxConnLifetime
+	p.pool, err = pgxpool.NewWithConfig(context.Background(), config)
+	return err
+}
+
+// pingPool acquires a connection from the pool, runs the checks and queries
+// on it and releases it. Acquiring includes making a new connection when no
+// idle one is left, whose phases are also timed on their own, so
+// --connect-timeout bounds acquiring as a whole.
+func (p *Pinger) pingPool(parent context.Context, i int) PingResult {
+	ctx, cancel := withTimeout(parent, "timeout", p.Timeout)
+	defer cancel()
+	timings := NewTimings()
+	ctx = WithTimings(ctx, timings)
+	start := time.Now()
+	acquireCtx, cancelAcquire := withTimeout(ctx, "connect-timeout", p.Timeouts.Connect)
+	defer cancelAcquire()
+	conn, err := p.pool.Acquire(acquireCtx)
+	timings.Add(PhaseAcquire, time.Since(start))
+	if err != nil {
+		res := PingResult{Status: StatusErr, Msg: "error acquiring connection", Err: err, Deadline: p.deadlineHit(acquireCtx, err)}
+		res.Pool = poolStats(p.pool.Stat())
+		return p.result(i, start, timings, res)
+	}
+	res := PingResult{
+		Server: timings.Server(conn.Conn().PgConn().Conn().RemoteAddr()),
+		TLS:    tlsInfo(conn.Conn().PgConn().Conn()),
+	}
+	queryCtx, cancelQuery := withTimeout(ctx, "query-timeout", p.Timeouts.Query)
+	defer cancelQuery()
+	ok := p.inspect(queryCtx, conn.Conn(), timings, i, &res)
+	var worst QueryStatus
+	if ok {
+		worst = p.query(withPhase(queryCtx, timings, PhaseQuery), conn.Conn(), &res)
+	}
+	conn.Release()
+	res.Pool = poolStats(p.pool.Stat())
+	if !ok {
+		res.Deadline = p.deadlineHit(queryCtx, res.Err)
+		return p.result(i, start, timings, res)
+	}
+	if worst.Status == StatusErr || worst.Status == StatusFail {
+		res.Status, res.Msg, res.Err = worst.Status, worst.Msg, worst.Err
+		res.Deadline = p.deadlineHit(queryCtx, res.Err)
+		return p.result(i, start, timings, res)
+	}
+	res.Status, res.Msg = p.status(res)
+	return p.result(i, start, timings, res)
+}
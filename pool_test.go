@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPingResultTextPool(t *testing.T) {
+	res := PingResult{
+		Time:      time.Date(2023, 3, 30, 15, 41, 14, 0, time.UTC),
+		Iteration: 1,
+		Status:    StatusOK,
+		Host:      "db.example.com",
+		Pool:      &PoolStats{Total: 3, Idle: 2, InUse: 1, Max: 4, CanceledAcquires: 5},
+		Duration:  3 * time.Millisecond,
+		Phases: map[Phase]time.Duration{
+			PhaseAcquire: 1 * time.Millisecond,
+			PhaseQuery:   2 * time.Millisecond,
+		},
+	}
+	assert.Equal(
+		t,
+		`2023-03-30T15:41:14Z     status="OK" host="db.example.com" pool_total=3 pool_idle=2 pool_in_use=1 pool_constructing=0 pool_max=4 pool_canceled_acquires=5 i=1 duration=3ms acquire=1ms query=2ms`, //nolint:lll
+		res.Text(),
+	)
+
+	line, err := res.JSON()
+	if err != nil {
+		t.Fatalf("error %v", err)
+	}
+	var got map[string]any
+	err = json.Unmarshal(line, &got)
+	if err != nil {
+		t.Fatalf("error %v", err)
+	}
+	assert.Equal(t, map[string]any{
+		"total":             3.0,
+		"idle":              2.0,
+		"in_use":            1.0,
+		"constructing":      0.0,
+		"max":               4.0,
+		"canceled_acquires": 5.0,
+	}, got["pool"])
+}
+
+func TestOpenPool(t *testing.T) {
+	connConfig, err := pgx.ParseConfig("postgres://pgping@db.example.com/app")
+	if err != nil {
+		t.Fatalf("error %v", err)
+	}
+	p := &Pinger{
+		ConnConfig: connConfig,
+		Pool: &PoolSettings{
+			MinConns:          0,
+			MaxConns:          8,
+			HealthCheckPeriod: 30 * time.Second,
+			MaxConnLifetime:   10 * time.Minute,
+		},
+	}
+	err = p.openPool()
+	if err != nil {
+		t.Fatalf("error %v", err)
+	}
+	defer p.pool.Close()
+	config := p.pool.Config()
+	assert.Equal(t, int32(8), config.MaxConns)
+	assert.Equal(t, 30*time.Second, config.HealthCheckPeriod)
+	assert.Equal(t, 10*time.Minute, config.MaxConnLifetime)
+	assert.Equal(t, "db.example.com", config.ConnConfig.Host)
+	assert.Equal(t, "app", config.ConnConfig.Database)
+}
+
+func TestPingPool(t *testing.T) {
+	server := newFakeServer(t)
+	captureResults(t)
+	connConfig := server.connConfig(t)
+	dialDelay := 50 * time.Millisecond
+	connConfig.DialFunc = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		time.Sleep(dialDelay)
+		var d net.Dialer
+		return d.DialContext(ctx, network, addr)
+	}
+	instrumentConnConfig(connConfig)
+	p := &Pinger{
+		ConnConfig: connConfig,
+		Pool:       &PoolSettings{MaxConns: 1, HealthCheckPeriod: time.Minute, MaxConnLifetime: time.Hour},
+		Timeout:    5 * time.Second,
+		Queries:    []Query{{SQL: "SELECT 1"}},
+	}
+	err := p.openPool()
+	if err != nil {
+		t.Fatalf("error %v", err)
+	}
+	defer p.closeHeld()
+
+	// the first ping waits for the pool to make a connection
+	res := p.Ping(context.Background(), 1)
+	assert.Equal(t, StatusOK, res.Status)
+	assert.GreaterOrEqual(t, res.Phases[PhaseAcquire], dialDelay)
+	assert.GreaterOrEqual(t, res.Phases[PhaseConnect], dialDelay)
+	assert.Contains(t, res.Phases, PhaseAuth)
+	assert.Contains(t, res.Phases, PhaseQuery)
+	assert.Equal(t, &PoolStats{Total: 1, Idle: 1, Max: 1}, res.Pool)
+
+	// the next reuses it
+	res = p.Ping(context.Background(), 2)
+	assert.Equal(t, StatusOK, res.Status)
+	assert.Less(t, res.Phases[PhaseAcquire], dialDelay)
+	assert.NotContains(t, res.Phases, PhaseConnect)
+	assert.Equal(t, 1, server.connections())
+}
+
+func TestFloodPool(t *testing.T) {
+	server := newFakeServer(t)
+	captureResults(t)
+	defer func(c int) { *count = c }(*count)
+	*count = 50
+	p := &Pinger{
+		ConnConfig: server.connConfig(t),
+		Pool:       &PoolSettings{MaxConns: 2, HealthCheckPeriod: time.Minute, MaxConnLifetime: time.Hour},
+		Timeout:    5 * time.Second,
+		Queries:    []Query{{SQL: "SELECT 1"}},
+		Stats:      NewStats(),
+	}
+	err := p.openPool()
+	if err != nil {
+		t.Fatalf("error %v", err)
+	}
+	f := &Flood{Pinger: p, Concurrency: 8, ReportInterval: time.Hour}
+	failed := f.Run(context.Background())
+	assert.False(t, failed)
+	assert.Equal(t, 50, p.Stats.Transmitted)
+	assert.LessOrEqual(t, server.connections(), 2, "flood bypassed the pool")
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+const defaultQuery = "SELECT 1"
+
+// Query is one of the queries every ping runs.
+type Query struct {
+	Name       string
+	SQL        string
+	Assertions Assertions
+}
+
+// QueryStatus is the outcome of one query of a ping.
+type QueryStatus struct {
+	Name     string
+	Status   string
+	Msg      string
+	Err      error
+	Duration time.Duration
+}
+
+// runQuery runs q on conn and checks its result.
+func runQuery(ctx context.Context, conn *pgx.Conn, q Query) QueryStatus {
+	start := time.Now()
+	status := QueryStatus{Name: q.Name}
+	result, err := readQuery(ctx, conn, q.SQL)
+	status.Duration = time.Since(start)
+	if err != nil {
+		status.Status, status.Msg, status.Err = StatusErr, "error querying", err
+		return status
+	}
+	if failure := q.Assertions.Check(result); failure != "" {
+		status.Status, status.Msg = StatusFail, failure
+		return status
+	}
+	status.Status = StatusOK
+	return status
+}
+
+func readQuery(ctx context.Context, conn *pgx.Conn, sql string) (QueryResult, error) {
+	rows, err := conn.Query(ctx, sql)
+	if err != nil {
+		return QueryResult{}, err
+	}
+	return readQueryResult(conn, rows)
+}
+
+// buildQueries puts together the queries from --query and --query-file.
+// Queries given with --query are named query1, query2, ... in order.
+func buildQueries(sqls []string, path string, spec AssertionSpec) ([]Query, error) {
+	if len(sqls) == 0 && path == "" {
+		sqls = []string{defaultQuery}
+	}
+	assertions, err := NewAssertions(spec)
+	if err != nil {
+		return nil, err
+	}
+	queries := make([]Query, 0, len(sqls))
+	for i, sql := range sqls {
+		queries = append(queries, Query{
+			Name:       "query" + strconv.Itoa(i+1),
+			SQL:        sql,
+			Assertions: assertions,
+		})
+	}
+	if path != "" {
+		fileQueries, err := readQueryFile(path, spec)
+		if err != nil {
+			return nil, err
+		}
+		queries = append(queries, fileQueries...)
+	}
+	seen := make(map[string]bool, len(queries))
+	for _, q := range queries {
+		if seen[q.Name] {
+			return nil, fmt.Errorf("query name `%s` is used more than once", q.Name)
+		}
+		seen[q.Name] = true
+	}
+	return queries, nil
+}
+
+var (
+	queryNameRe   = regexp.MustCompile(`^--\s*name:\s*(\S+)\s*$`)
+	queryExpectRe = regexp.MustCompile(`^--\s*expect-(value|compare|regex|rows|type):\s?(.*)$`)
+)
+
+// readQueryFile reads named queries from path. Each query starts with a
+// "-- name: <name>" line, may be followed by "-- expect-<kind>: <value>"
+// lines setting its assertions the way the --expect-* flags do, and runs
+// until the next name line:
+//
+//	-- name: failed_jobs
+//	-- expect-compare: < 10
+//	SELECT count(*) FROM jobs WHERE failed;
+//
+// Assertions not set in the file default to those given by flag.
+func readQueryFile(path string, defaults AssertionSpec) ([]Query, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var (
+		queries []Query
+		name    string
+		spec    AssertionSpec
+		sql     strings.Builder
+		started int
+	)
+	finish := func() error {
+		if name == "" {
+			return nil
+		}
+		q := strings.TrimSpace(sql.String())
+		q = strings.TrimSpace(strings.TrimSuffix(q, ";"))
+		if q == "" {
+			return fmt.Errorf("%s:%d: query `%s` is empty", path, started, name)
+		}
+		assertions, err := NewAssertions(spec)
+		if err != nil {
+			return fmt.Errorf("%s:%d: query `%s`: %w", path, started, name, err)
+		}
+		queries = append(queries, Query{Name: name, SQL: q, Assertions: assertions})
+		return nil
+	}
+
+	scanner := bufio.NewScanner(f)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := scanner.Text()
+		trimmed := strings.TrimSpace(line)
+		if match := queryNameRe.FindStringSubmatch(trimmed); match != nil {
+			err := finish()
+			if err != nil {
+				return nil, err
+			}
+			name, spec, started = match[1], defaults, lineNo
+			sql.Reset()
+			continue
+		}
+		if name == "" {
+			if trimmed == "" || strings.HasPrefix(trimmed, "--") {
+				continue
+			}
+			return nil, fmt.Errorf("%s:%d: query before the first `-- name:` line", path, lineNo)
+		}
+		if match := queryExpectRe.FindStringSubmatch(trimmed); match != nil {
+			spec.set(match[1], strings.TrimSpace(match[2]))
+			continue
+		}
+		sql.WriteString(line)
+		sql.WriteString("\n")
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	err = finish()
+	if err != nil {
+		return nil, err
+	}
+	if len(queries) == 0 {
+		return nil, fmt.Errorf("%s: no queries found", path)
+	}
+	return queries, nil
+}
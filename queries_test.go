@@ -0,0 +1,102 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func writeQueryFile(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "queries.sql")
+	err := os.WriteFile(path, []byte(content), 0o600)
+	if err != nil {
+		t.Fatalf("error writing query file %v", err)
+	}
+	return path
+}
+
+func TestReadQueryFile(t *testing.T) {
+	path := writeQueryFile(t, `-- checks run by pgping
+
+-- name: version
+-- expect-regex: ^16\.
+SHOW server_version;
+
+-- name: failed_jobs
+-- expect-compare: < 10
+SELECT count(*)
+  FROM jobs
+ WHERE failed;
+`)
+	queries, err := readQueryFile(path, AssertionSpec{Type: "text"})
+	if err != nil {
+		t.Fatalf("error %v", err)
+	}
+	if assert.Len(t, queries, 2) {
+		assert.Equal(t, "version", queries[0].Name)
+		assert.Equal(t, "SHOW server_version", queries[0].SQL)
+		assert.Equal(t, `^16\.`, queries[0].Assertions.Regex.String())
+		assert.Equal(t, "text", queries[0].Assertions.Type)
+		assert.Nil(t, queries[0].Assertions.Compare)
+
+		assert.Equal(t, "failed_jobs", queries[1].Name)
+		assert.Equal(t, "SELECT count(*)\n  FROM jobs\n WHERE failed", queries[1].SQL)
+		assert.Equal(t, &Comparison{Op: "<", Value: 10}, queries[1].Assertions.Compare)
+		assert.Nil(t, queries[1].Assertions.Regex)
+	}
+}
+
+func TestReadQueryFileErrors(t *testing.T) {
+	tests := map[string]struct {
+		content string
+		expect  string
+	}{
+		"query before name": {
+			content: "SELECT 1;\n-- name: one\nSELECT 1;\n",
+			expect:  ":1: query before the first `-- name:` line",
+		},
+		"empty query": {
+			content: "-- name: one\nSELECT 1;\n\n-- name: two\n;\n",
+			expect:  ":4: query `two` is empty",
+		},
+		"bad assertion": {
+			content: "-- name: one\n-- expect-compare: lots\nSELECT 1;\n",
+			expect:  ":1: query `one`: invalid comparison `lots`",
+		},
+		"no queries": {
+			content: "-- nothing here\n",
+			expect:  "no queries found",
+		},
+	}
+	for desc, tc := range tests {
+		_, err := readQueryFile(writeQueryFile(t, tc.content), AssertionSpec{})
+		assert.ErrorContains(t, err, tc.expect, desc)
+	}
+}
+
+func TestBuildQueries(t *testing.T) {
+	queries, err := buildQueries(nil, "", AssertionSpec{})
+	if err != nil {
+		t.Fatalf("error %v", err)
+	}
+	assert.Equal(t, []Query{{Name: "query1", SQL: "SELECT 1"}}, queries)
+
+	path := writeQueryFile(t, "-- name: jobs\nSELECT count(*) FROM jobs;\n")
+	queries, err = buildQueries([]string{"SELECT 1", "SELECT 2"}, path, AssertionSpec{Value: "1"})
+	if err != nil {
+		t.Fatalf("error %v", err)
+	}
+	names := make([]string, 0, len(queries))
+	for _, q := range queries {
+		names = append(names, q.Name)
+		assert.Equal(t, "1", q.Assertions.Value, q.Name)
+	}
+	assert.Equal(t, []string{"query1", "query2", "jobs"}, names)
+
+	path = writeQueryFile(t, "-- name: query1\nSELECT 1;\n")
+	_, err = buildQueries([]string{"SELECT 1"}, path, AssertionSpec{})
+	assert.ErrorContains(t, err, "query name `query1` is used more than once")
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+const (
+	CheckPing        = "ping"
+	CheckReplication = "replication"
+)
+
+// standbyReplicationQuery reports how far replay is behind what the standby
+// has received, in bytes and in time. When everything received has been
+// replayed the standby is caught up, however long ago the last transaction
+// was, so the time lag is zero.
+const standbyReplicationQuery = `SELECT
+	COALESCE(pg_wal_lsn_diff(pg_last_wal_receive_lsn(), pg_last_wal_replay_lsn()), 0)::bigint,
+	CASE WHEN pg_last_wal_receive_lsn() = pg_last_wal_replay_lsn() THEN 0
+	ELSE COALESCE(EXTRACT(epoch FROM now() - pg_last_xact_replay_timestamp()), 0)
+	END::float8`
+
+// primaryReplicationQuery summarizes the standbys streaming from a primary
+// and the replication slots that hold WAL back for them.
+const primaryReplicationQuery = `SELECT
+	(SELECT count(*) FROM pg_stat_replication)::int,
+	(SELECT COALESCE(max(pg_wal_lsn_diff(pg_current_wal_lsn(), replay_lsn)), 0) FROM pg_stat_replication)::bigint,
+	(SELECT COALESCE(EXTRACT(epoch FROM max(replay_lag)), 0) FROM pg_stat_replication)::float8,
+	(SELECT count(*) FROM pg_replication_slots)::int,
+	(SELECT count(*) FROM pg_replication_slots WHERE NOT active)::int,
+	(SELECT COALESCE(max(pg_wal_lsn_diff(pg_current_wal_lsn(), restart_lsn)), 0) FROM pg_replication_slots)::bigint`
+
+// Replication is the state of replication as seen from one server. On a
+// standby Lag and LagBytes are its own replay lag; on a primary they are the
+// worst of its standbys'.
+type Replication struct {
+	Role     string
+	LagBytes int64
+	Lag      time.Duration
+
+	// Only filled in on a primary.
+	Replicas      int
+	Slots         int
+	InactiveSlots int
+	RetainedBytes int64
+}
+
+// ReplicationThresholds turn replication lag into WARN or FAIL. Zero
+// disables a threshold.
+type ReplicationThresholds struct {
+	WarnLag      time.Duration
+	CritLag      time.Duration
+	WarnLagBytes int64
+	CritLagBytes int64
+}
+
+func queryReplication(ctx context.Context, conn *pgx.Conn) (*Replication, error) {
+	var inRecovery bool
+	err := conn.QueryRow(ctx, "SELECT pg_is_in_recovery()").Scan(&inRecovery)
+	if err != nil {
+		return nil, err
+	}
+	var lagSeconds float64
+	if inRecovery {
+		r := &Replication{Role: RoleStandby}
+		err = conn.QueryRow(ctx, standbyReplicationQuery).Scan(&r.LagBytes, &lagSeconds)
+		if err != nil {
+			return nil, err
+		}
+		r.Lag = time.Duration(lagSeconds * float64(time.Second))
+		return r, nil
+	}
+	r := &Replication{Role: RolePrimary}
+	err = conn.QueryRow(ctx, primaryReplicationQuery).Scan(
+		&r.Replicas,
+		&r.LagBytes,
+		&lagSeconds,
+		&r.Slots,
+		&r.InactiveSlots,
+		&r.RetainedBytes,
+	)
+	if err != nil {
+		return nil, err
+	}
+	r.Lag = time.Duration(lagSeconds * float64(time.Second))
+	return r, nil
+}
+
+// Status checks r against thresholds and returns the status the ping should
+// have along with why. WAL retained by slots counts against the byte
+// thresholds as well, since an abandoned slot fills the disk just the same.
+func (r *Replication) Status(thresholds ReplicationThresholds) (string, string) {
+	exceeds := func(value, limit int64) bool {
+		return limit > 0 && value >= limit
+	}
+	checks := []struct {
+		status string
+		failed bool
+		msg    string
+	}{
+		{StatusFail, exceeds(int64(r.Lag), int64(thresholds.CritLag)), fmt.Sprintf("replication lag %s over %s", r.Lag, thresholds.CritLag)},
+		{StatusFail, exceeds(r.LagBytes, thresholds.CritLagBytes), fmt.Sprintf("replication lag %d bytes over %d", r.LagBytes, thresholds.CritLagBytes)},
+		{StatusFail, exceeds(r.RetainedBytes, thresholds.CritLagBytes), fmt.Sprintf("slots retain %d bytes of WAL, over %d", r.RetainedBytes, thresholds.CritLagBytes)},
+		{StatusWarn, exceeds(int64(r.Lag), int64(thresholds.WarnLag)), fmt.Sprintf("replication lag %s over %s", r.Lag, thresholds.WarnLag)},
+		{StatusWarn, exceeds(r.LagBytes, thresholds.WarnLagBytes), fmt.Sprintf("replication lag %d bytes over %d", r.LagBytes, thresholds.WarnLagBytes)},
+		{StatusWarn, exceeds(r.RetainedBytes, thresholds.WarnLagBytes), fmt.Sprintf("slots retain %d bytes of WAL, over %d", r.RetainedBytes, thresholds.WarnLagBytes)},
+	}
+	for _, check := range checks {
+		if check.failed {
+			return check.status, check.msg
+		}
+	}
+	return StatusOK, ""
+}
+
+// kvs renders r for the text output.
+func (r *Replication) kvs() []string {
+	kvs := []string{
+		kv("lag_bytes", r.LagBytes),
+		kv("lag", r.Lag),
+	}
+	if r.Role == RolePrimary {
+		kvs = append(kvs,
+			kv("replicas", r.Replicas),
+			kv("slots", r.Slots),
+			kv("inactive_slots", r.InactiveSlots),
+			kv("retained_bytes", r.RetainedBytes),
+		)
+	}
+	return kvs
+}
+
+type jsonReplication struct {
+	LagBytes      int64 `json:"lag_bytes"`
+	LagNs         int64 `json:"lag_ns"`
+	Replicas      *int  `json:"replicas,omitempty"`
+	Slots         *int  `json:"slots,omitempty"`
+	InactiveSlots *int  `json:"inactive_slots,omitempty"`
+	RetainedBytes int64 `json:"retained_bytes,omitempty"`
+}
+
+func (r *Replication) json() *jsonReplication {
+	out := &jsonReplication{
+		LagBytes: r.LagBytes,
+		LagNs:    r.Lag.Nanoseconds(),
+	}
+	if r.Role == RolePrimary {
+		out.Replicas = &r.Replicas
+		out.Slots = &r.Slots
+		out.InactiveSlots = &r.InactiveSlots
+		out.RetainedBytes = r.RetainedBytes
+	}
+	return out
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReplicationStatus(t *testing.T) {
+	thresholds := ReplicationThresholds{
+		WarnLag:      10 * time.Second,
+		CritLag:      time.Minute,
+		WarnLagBytes: 16 << 20,
+		CritLagBytes: 1 << 30,
+	}
+	tests := map[string]struct {
+		replication    Replication
+		thresholds     ReplicationThresholds
+		expectedStatus string
+		expectedMsg    string
+	}{
+		"caught up": {
+			replication:    Replication{Role: RoleStandby},
+			thresholds:     thresholds,
+			expectedStatus: StatusOK,
+		},
+		"no thresholds": {
+			replication:    Replication{Role: RoleStandby, Lag: time.Hour, LagBytes: 1 << 40},
+			expectedStatus: StatusOK,
+		},
+		"warn lag": {
+			replication:    Replication{Role: RoleStandby, Lag: 15 * time.Second},
+			thresholds:     thresholds,
+			expectedStatus: StatusWarn,
+			expectedMsg:    "replication lag 15s over 10s",
+		},
+		"crit lag beats warn bytes": {
+			replication:    Replication{Role: RoleStandby, Lag: 2 * time.Minute, LagBytes: 32 << 20},
+			thresholds:     thresholds,
+			expectedStatus: StatusFail,
+			expectedMsg:    "replication lag 2m0s over 1m0s",
+		},
+		"crit bytes": {
+			replication:    Replication{Role: RolePrimary, LagBytes: 2 << 30},
+			thresholds:     thresholds,
+			expectedStatus: StatusFail,
+			expectedMsg:    "replication lag 2147483648 bytes over 1073741824",
+		},
+		"warn retained by inactive slot": {
+			replication:    Replication{Role: RolePrimary, Slots: 1, InactiveSlots: 1, RetainedBytes: 64 << 20},
+			thresholds:     thresholds,
+			expectedStatus: StatusWarn,
+			expectedMsg:    "slots retain 67108864 bytes of WAL, over 16777216",
+		},
+	}
+	for desc, tc := range tests {
+		status, msg := tc.replication.Status(tc.thresholds)
+		assert.Equal(t, tc.expectedStatus, status, desc)
+		assert.Equal(t, tc.expectedMsg, msg, desc)
+	}
+}
+
+func TestReplicationText(t *testing.T) {
+	res := PingResult{
+		Time:        time.Date(2023, 3, 30, 15, 41, 14, 0, time.UTC),
+		Iteration:   1,
+		Status:      StatusOK,
+		Host:        "db.example.com",
+		Role:        RolePrimary,
+		Replication: &Replication{Role: RolePrimary, LagBytes: 128, Lag: 20 * time.Millisecond, Replicas: 2, Slots: 3, InactiveSlots: 1, RetainedBytes: 4096},
+		Duration:    2 * time.Millisecond,
+	}
+	assert.Equal(
+		t,
+		`2023-03-30T15:41:14Z     status="OK" host="db.example.com" role="primary" lag_bytes=128 lag=20ms replicas=2 slots=3 inactive_slots=1 retained_bytes=4096 i=1 duration=2ms`, //nolint:lll
+		res.Text(),
+	)
+}
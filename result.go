@@ -0,0 +1,414 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// timestampFormat is how times are written in text output.
+const timestampFormat = "2006-01-02T15:04:05.999Z"
+
+const (
+	StatusOK   = "OK"
+	StatusWarn = "WARN"
+	StatusFail = "FAIL"
+	StatusErr  = "ERR"
+)
+
+// statusSeverity orders the statuses from best to worst.
+var statusSeverity = map[string]int{
+	StatusOK:   0,
+	StatusWarn: 1,
+	StatusFail: 2,
+	StatusErr:  3,
+}
+
+// PingResult is the outcome of a single ping.
+type PingResult struct {
+	Time      time.Time
+	Iteration int
+	Target    string
+	Status    string
+	Event     string
+	Host      string
+	Port      uint16
+	Database  string
+	Server    string
+	TLS       *TLSInfo
+	Role      string
+	Timeline  int64
+	// Replication is filled in by --check replication.
+	Replication *Replication
+	// Visibility is how long the heartbeat took to show up on a replica
+	// under --check visibility.
+	Visibility time.Duration
+	// Pool is filled in in pool mode.
+	Pool *PoolStats
+	// Queries has the outcome of each query when more than one ran.
+	Queries []QueryStatus
+	Msg     string
+	// Deadline names the timeout flag whose deadline made the ping fail,
+	// if one did.
+	Deadline string
+	Err      error
+	Duration time.Duration
+	Phases   map[Phase]time.Duration
+}
+
+// Pass reports whether the ping got through. A WARN did: the server answered,
+// it's just not as healthy as it should be.
+func (r PingResult) Pass() bool {
+	return r.Status == StatusOK || r.Status == StatusWarn
+}
+
+// ErrorClass buckets Err into a short, stable name that is easier to group
+// and alert on than the error message itself.
+func (r PingResult) ErrorClass() string {
+	return errorClass(r.Err)
+}
+
+func errorClass(err error) string {
+	if err == nil {
+		return ""
+	}
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		return sqlStateClass(pgErr.Code)
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return "timeout"
+	}
+	if errors.Is(err, context.Canceled) {
+		return "canceled"
+	}
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return "dns"
+	}
+	if errors.Is(err, syscall.ECONNREFUSED) {
+		return "connection_refused"
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return "timeout"
+	}
+	var (
+		recordHeaderErr tls.RecordHeaderError
+		unknownAuthErr  x509.UnknownAuthorityError
+		certInvalidErr  x509.CertificateInvalidError
+		hostnameErr     x509.HostnameError
+	)
+	if errors.As(err, &recordHeaderErr) ||
+		errors.As(err, &unknownAuthErr) ||
+		errors.As(err, &certInvalidErr) ||
+		errors.As(err, &hostnameErr) ||
+		strings.Contains(err.Error(), "tls error") {
+		return "tls"
+	}
+	var connectErr *pgconn.ConnectError
+	if errors.As(err, &connectErr) {
+		return "connection"
+	}
+	return "other"
+}
+
+// sqlStateClass names the SQLSTATE classes that are likely to come up when
+// pinging; anything else is reported by its two character class code.
+func sqlStateClass(code string) string {
+	if len(code) < 2 {
+		return "server"
+	}
+	switch code[:2] {
+	case "08":
+		return "connection"
+	case "28":
+		return "auth"
+	case "3D":
+		return "invalid_database"
+	case "42":
+		return "syntax"
+	case "53":
+		return "insufficient_resources"
+	case "57":
+		return "operator_intervention"
+	default:
+		return "sqlstate_" + code[:2]
+	}
+}
+
+// Text renders the result as a line of key=value pairs.
+func (r PingResult) Text() string {
+	kvs := make([]string, 0)
+	if r.Target != "" {
+		kvs = append(kvs, kv("target", r.Target))
+	}
+	kvs = append(kvs, kv("status", r.Status))
+	if r.Event != "" {
+		kvs = append(kvs, kv("event", r.Event))
+	}
+	kvs = append(kvs, kv("host", r.Host))
+	if r.Server != "" {
+		kvs = append(kvs, kv("server", r.Server))
+	}
+	if r.TLS != nil {
+		kvs = append(kvs, kv("tls_version", r.TLS.Version))
+		kvs = append(kvs, kv("tls_cipher", r.TLS.Cipher))
+		kvs = append(kvs, kv("tls_subject", r.TLS.Subject))
+		if !r.TLS.Expiry.IsZero() {
+			kvs = append(kvs, kv("cert_days_left", r.TLS.DaysLeft(r.Time)))
+		}
+	}
+	if r.Role != "" {
+		kvs = append(kvs, kv("role", r.Role))
+	}
+	if r.Timeline != 0 {
+		kvs = append(kvs, kv("timeline", r.Timeline))
+	}
+	if r.Replication != nil {
+		kvs = append(kvs, r.Replication.kvs()...)
+	}
+	if r.Visibility != 0 {
+		kvs = append(kvs, kv("visibility", r.Visibility))
+	}
+	if r.Pool != nil {
+		kvs = append(kvs, r.Pool.kvs()...)
+	}
+	if r.Msg != "" {
+		kvs = append(kvs, kv("msg", r.Msg))
+	}
+	if r.Deadline != "" {
+		kvs = append(kvs, kv("deadline", r.Deadline))
+	}
+	if r.Err != nil {
+		kvs = append(kvs, kv("err", r.Err))
+	}
+	kvs = append(kvs, kv("i", r.Iteration))
+	kvs = append(kvs, kv("duration", r.Duration))
+	for _, phase := range Phases {
+		if d, ok := r.Phases[phase]; ok {
+			kvs = append(kvs, kv(string(phase), d))
+		}
+	}
+	for _, q := range r.Queries {
+		kvs = append(kvs, kv(q.Name+".status", q.Status))
+		kvs = append(kvs, kv(q.Name+".duration", q.Duration))
+	}
+	var format strings.Builder
+	format.WriteString(fmt.Sprintf("%-25s", r.Time.Format(timestampFormat)))
+	format.WriteString(strings.Join(kvs, " "))
+	return format.String()
+}
+
+type jsonResult struct {
+	Type         string           `json:"type"`
+	Timestamp    time.Time        `json:"timestamp"`
+	Iteration    int              `json:"iteration"`
+	Target       string           `json:"target,omitempty"`
+	Status       string           `json:"status"`
+	Event        string           `json:"event,omitempty"`
+	DurationNs   int64            `json:"duration_ns"`
+	Host         string           `json:"host"`
+	Port         uint16           `json:"port"`
+	Database     string           `json:"database"`
+	Server       string           `json:"server,omitempty"`
+	TLS          *jsonTLS         `json:"tls,omitempty"`
+	Role         string           `json:"role,omitempty"`
+	Timeline     int64            `json:"timeline,omitempty"`
+	Replication  *jsonReplication `json:"replication,omitempty"`
+	VisibilityNs int64            `json:"visibility_ns,omitempty"`
+	Pool         *jsonPoolStats   `json:"pool,omitempty"`
+	Msg          string           `json:"msg,omitempty"`
+	Deadline     string           `json:"deadline,omitempty"`
+	Error        string           `json:"error,omitempty"`
+	ErrorClass   string           `json:"error_class,omitempty"`
+	PhasesNs     map[Phase]int64  `json:"phases_ns,omitempty"`
+	Queries      []jsonQuery      `json:"queries,omitempty"`
+}
+
+type jsonQuery struct {
+	Name       string `json:"name"`
+	Status     string `json:"status"`
+	DurationNs int64  `json:"duration_ns"`
+	Msg        string `json:"msg,omitempty"`
+	Error      string `json:"error,omitempty"`
+	ErrorClass string `json:"error_class,omitempty"`
+}
+
+type jsonTLS struct {
+	Version  string     `json:"version"`
+	Cipher   string     `json:"cipher"`
+	Subject  string     `json:"subject,omitempty"`
+	Expiry   *time.Time `json:"cert_expiry,omitempty"`
+	DaysLeft *int       `json:"cert_days_left,omitempty"`
+}
+
+// JSON renders the result as a single JSON object.
+func (r PingResult) JSON() ([]byte, error) {
+	out := jsonResult{
+		Type:         "ping",
+		Timestamp:    r.Time.UTC(),
+		Iteration:    r.Iteration,
+		Target:       r.Target,
+		Status:       r.Status,
+		Event:        r.Event,
+		DurationNs:   r.Duration.Nanoseconds(),
+		Host:         r.Host,
+		Port:         r.Port,
+		Database:     r.Database,
+		Server:       r.Server,
+		Role:         r.Role,
+		Timeline:     r.Timeline,
+		VisibilityNs: r.Visibility.Nanoseconds(),
+		Msg:          r.Msg,
+		Deadline:     r.Deadline,
+		ErrorClass:   r.ErrorClass(),
+	}
+	if r.Err != nil {
+		out.Error = r.Err.Error()
+	}
+	if r.TLS != nil {
+		out.TLS = &jsonTLS{
+			Version: r.TLS.Version,
+			Cipher:  r.TLS.Cipher,
+			Subject: r.TLS.Subject,
+		}
+		if !r.TLS.Expiry.IsZero() {
+			expiry := r.TLS.Expiry.UTC()
+			daysLeft := r.TLS.DaysLeft(r.Time)
+			out.TLS.Expiry = &expiry
+			out.TLS.DaysLeft = &daysLeft
+		}
+	}
+	if r.Replication != nil {
+		out.Replication = r.Replication.json()
+	}
+	if r.Pool != nil {
+		out.Pool = r.Pool.json()
+	}
+	if len(r.Phases) > 0 {
+		out.PhasesNs = make(map[Phase]int64, len(r.Phases))
+		for phase, d := range r.Phases {
+			out.PhasesNs[phase] = d.Nanoseconds()
+		}
+	}
+	for _, q := range r.Queries {
+		query := jsonQuery{
+			Name:       q.Name,
+			Status:     q.Status,
+			DurationNs: q.Duration.Nanoseconds(),
+			Msg:        q.Msg,
+			ErrorClass: errorClass(q.Err),
+		}
+		if q.Err != nil {
+			query.Error = q.Err.Error()
+		}
+		out.Queries = append(out.Queries, query)
+	}
+	return json.Marshal(out)
+}
+
+// Event is something notable that happened between pings, such as the
+// accepting server changing. Attrs are printed in order after the event
+// name.
+type Event struct {
+	Time   time.Time
+	Target string
+	Name   string
+	Attrs  []EventAttr
+}
+
+type EventAttr struct {
+	Key   string
+	Value any
+}
+
+func (e Event) Text() string {
+	kvs := make([]string, 0)
+	if e.Target != "" {
+		kvs = append(kvs, kv("target", e.Target))
+	}
+	kvs = append(kvs, kv("event", e.Name))
+	for _, attr := range e.Attrs {
+		kvs = append(kvs, kv(attr.Key, attr.Value))
+	}
+	var format strings.Builder
+	format.WriteString(fmt.Sprintf("%-25s", e.Time.Format(timestampFormat)))
+	format.WriteString(strings.Join(kvs, " "))
+	return format.String()
+}
+
+func (e Event) JSON() ([]byte, error) {
+	out := map[string]any{
+		"type":      "event",
+		"timestamp": e.Time.UTC(),
+		"event":     e.Name,
+	}
+	if e.Target != "" {
+		out["target"] = e.Target
+	}
+	for _, attr := range e.Attrs {
+		switch v := attr.Value.(type) {
+		case time.Duration:
+			out[attr.Key+"_ns"] = v.Nanoseconds()
+		case time.Time:
+			out[attr.Key] = v.UTC()
+		case error:
+			out[attr.Key] = v.Error()
+		default:
+			out[attr.Key] = v
+		}
+	}
+	return json.Marshal(out)
+}
+
+func printEvent(e Event) {
+	logOutputs(func(format string) []string {
+		if format == "json" {
+			line, err := e.JSON()
+			if err != nil {
+				logger.Error("error encoding event", "err", err)
+				return nil
+			}
+			return []string{string(line)}
+		}
+		return []string{e.Text()}
+	})
+}
+
+func printResult(r PingResult) {
+	logOutputs(func(format string) []string {
+		if format == "json" {
+			line, err := r.JSON()
+			if err != nil {
+				logger.Error("error encoding result", "err", err)
+				return nil
+			}
+			return []string{string(line)}
+		}
+		return []string{r.Text()}
+	})
+}
+
+func printSummary(name string, stats *Stats) {
+	logOutputs(func(format string) []string {
+		if format == "json" {
+			line, err := json.Marshal(stats.JSON(name))
+			if err != nil {
+				logger.Error("error encoding summary", "err", err)
+				return nil
+			}
+			return []string{string(line)}
+		}
+		return append([]string{""}, stats.Summary(name)...)
+	})
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestErrorClass(t *testing.T) {
+	tests := map[string]struct {
+		err    error
+		expect string
+	}{
+		"nil": {
+			err:    nil,
+			expect: "",
+		},
+		"timeout": {
+			err:    fmt.Errorf("dial error: %w", context.DeadlineExceeded),
+			expect: "timeout",
+		},
+		"dns": {
+			err:    &net.DNSError{Err: "no such host", Name: "db.example.com"},
+			expect: "dns",
+		},
+		"connection refused": {
+			err:    fmt.Errorf("dial error: %w", syscall.ECONNREFUSED),
+			expect: "connection_refused",
+		},
+		"auth": {
+			err:    &pgconn.PgError{Code: "28P01"},
+			expect: "auth",
+		},
+		"other sqlstate": {
+			err:    &pgconn.PgError{Code: "XX000"},
+			expect: "sqlstate_XX",
+		},
+		"unknown": {
+			err:    fmt.Errorf("something else"),
+			expect: "other",
+		},
+	}
+	for desc, tc := range tests {
+		assert.Equal(t, tc.expect, errorClass(tc.err), desc)
+	}
+}
+
+func TestPingResultText(t *testing.T) {
+	res := PingResult{
+		Time:      time.Date(2023, 3, 30, 15, 41, 14, 0, time.UTC),
+		Iteration: 3,
+		Target:    "db.example.com",
+		Status:    StatusErr,
+		Host:      "db.example.com",
+		Msg:       "error querying",
+		Err:       fmt.Errorf(`relation "x" does not exist`),
+		Duration:  2 * time.Millisecond,
+		Phases: map[Phase]time.Duration{
+			PhaseQuery:   1 * time.Millisecond,
+			PhaseConnect: 1 * time.Millisecond,
+		},
+	}
+	assert.Equal(
+		t,
+		`2023-03-30T15:41:14Z     target="db.example.com" status="ERR" host="db.example.com" msg="error querying" err="relation \"x\" does not exist" i=3 duration=2ms connect=1ms query=1ms`, //nolint:lll
+		res.Text(),
+	)
+}
+
+func TestPingResultTextQueries(t *testing.T) {
+	res := PingResult{
+		Time:      time.Date(2023, 3, 30, 15, 41, 14, 0, time.UTC),
+		Iteration: 1,
+		Status:    StatusFail,
+		Host:      "db.example.com",
+		Msg:       "jobs: expected value < 10, got 12",
+		Duration:  3 * time.Millisecond,
+		Queries: []QueryStatus{
+			{Name: "locks", Status: StatusOK, Duration: 1 * time.Millisecond},
+			{Name: "jobs", Status: StatusFail, Msg: "expected value < 10, got 12", Duration: 2 * time.Millisecond},
+		},
+	}
+	assert.Equal(
+		t,
+		`2023-03-30T15:41:14Z     status="FAIL" host="db.example.com" msg="jobs: expected value < 10, got 12" i=1 duration=3ms locks.status="OK" locks.duration=1ms jobs.status="FAIL" jobs.duration=2ms`, //nolint:lll
+		res.Text(),
+	)
+}
+
+func TestPingResultTextDeadline(t *testing.T) {
+	res := PingResult{
+		Time:      time.Date(2023, 3, 30, 15, 41, 14, 0, time.UTC),
+		Iteration: 1,
+		Status:    StatusErr,
+		Host:      "db.example.com",
+		Msg:       "error querying",
+		Deadline:  "query-timeout",
+		Err:       context.DeadlineExceeded,
+		Duration:  2 * time.Second,
+	}
+	assert.Equal(
+		t,
+		`2023-03-30T15:41:14Z     status="ERR" host="db.example.com" msg="error querying" deadline="query-timeout" err="context deadline exceeded" i=1 duration=2s`, //nolint:lll
+		res.Text(),
+	)
+}
+
+func TestPingResultJSON(t *testing.T) {
+	res := PingResult{
+		Time:      time.Date(2023, 3, 30, 15, 41, 14, 0, time.UTC),
+		Iteration: 1,
+		Status:    StatusErr,
+		Host:      "db.example.com",
+		Port:      5432,
+		Database:  "app",
+		Msg:       "error connecting",
+		Err:       fmt.Errorf("dial error: %w", syscall.ECONNREFUSED),
+		Duration:  1500 * time.Microsecond,
+	}
+	line, err := res.JSON()
+	if err != nil {
+		t.Fatalf("error %v", err)
+	}
+	var got map[string]any
+	err = json.Unmarshal(line, &got)
+	if err != nil {
+		t.Fatalf("error %v", err)
+	}
+	assert.Equal(t, map[string]any{
+		"type":        "ping",
+		"timestamp":   "2023-03-30T15:41:14Z",
+		"iteration":   1.0,
+		"status":      "ERR",
+		"duration_ns": 1500000.0,
+		"host":        "db.example.com",
+		"port":        5432.0,
+		"database":    "app",
+		"msg":         "error connecting",
+		"error":       "dial error: connection refused",
+		"error_class": "connection_refused",
+	}, got)
+}
+
+func TestEventText(t *testing.T) {
+	e := Event{
+		Time:   time.Date(2023, 3, 30, 15, 41, 14, 0, time.UTC),
+		Target: "db",
+		Name:   "server_changed",
+		Attrs: []EventAttr{
+			{"from", "db1.example.com:5432"},
+			{"to", "db2.example.com:5432"},
+			{"i", 4},
+		},
+	}
+	assert.Equal(
+		t,
+		`2023-03-30T15:41:14Z     target="db" event="server_changed" from="db1.example.com:5432" to="db2.example.com:5432" i=4`, //nolint:lll
+		e.Text(),
+	)
+}
+
+func TestEventJSON(t *testing.T) {
+	e := Event{
+		Time: time.Date(2023, 3, 30, 15, 41, 14, 0, time.UTC),
+		Name: "server_changed",
+		Attrs: []EventAttr{
+			{"from", "db1.example.com:5432"},
+			{"after", 1500 * time.Microsecond},
+		},
+	}
+	line, err := e.JSON()
+	if err != nil {
+		t.Fatalf("error %v", err)
+	}
+	var got map[string]any
+	err = json.Unmarshal(line, &got)
+	if err != nil {
+		t.Fatalf("error %v", err)
+	}
+	assert.Equal(t, map[string]any{
+		"type":      "event",
+		"timestamp": "2023-03-30T15:41:14Z",
+		"event":     "server_changed",
+		"from":      "db1.example.com:5432",
+		"after_ns":  1500000.0,
+	}, got)
+}
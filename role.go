@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+const (
+	RolePrimary = "primary"
+	RoleStandby = "standby"
+)
+
+// roleQuery reports whether the server is in recovery and the timeline it
+// is on. A primary's timeline comes from the WAL it is writing; a standby's
+// from the WAL it is receiving, or failing that (e.g. when it only restores
+// from an archive) its last checkpoint.
+const roleQuery = `SELECT pg_is_in_recovery(),
+	CASE WHEN pg_is_in_recovery() THEN
+		COALESCE(
+			(SELECT received_tli FROM pg_stat_wal_receiver),
+			(SELECT timeline_id FROM pg_control_checkpoint())
+		)::bigint
+	ELSE
+		('x' || substr(pg_walfile_name(pg_current_wal_lsn()), 1, 8))::bit(32)::bigint
+	END`
+
+// queryRole finds out whether conn is connected to a primary or a standby
+// and which timeline it is on.
+func queryRole(ctx context.Context, conn *pgx.Conn) (string, int64, error) {
+	var (
+		inRecovery bool
+		timeline   int64
+	)
+	err := conn.QueryRow(ctx, roleQuery).Scan(&inRecovery, &timeline)
+	if err != nil {
+		return "", 0, err
+	}
+	if inRecovery {
+		return RoleStandby, timeline, nil
+	}
+	return RolePrimary, timeline, nil
+}
+
+// roleSighting is the role and timeline as of the last ping that saw them.
+type roleSighting struct {
+	Role     string
+	Timeline int64
+	Time     time.Time
+}
+
+// roleChange builds the event for the role or timeline changing between the
+// last ping in the old role and res, the first one in the new role. It
+// returns false if nothing changed.
+func roleChange(last roleSighting, res PingResult) (Event, bool) {
+	if last.Role == "" || (last.Role == res.Role && last.Timeline == res.Timeline) {
+		return Event{}, false
+	}
+	name := "timeline_changed"
+	if last.Role != res.Role {
+		name = "role_changed"
+	}
+	return Event{
+		Time:   res.Time,
+		Target: res.Target,
+		Name:   name,
+		Attrs: []EventAttr{
+			{"from", last.Role},
+			{"to", res.Role},
+			{"from_timeline", last.Timeline},
+			{"to_timeline", res.Timeline},
+			{"last_old", last.Time},
+			{"first_new", res.Time},
+			{"outage", res.Time.Sub(last.Time)},
+			{"i", res.Iteration},
+		},
+	}, true
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRoleChange(t *testing.T) {
+	before := time.Date(2023, 3, 30, 15, 41, 14, 0, time.UTC)
+	after := before.Add(3 * time.Second)
+	tests := map[string]struct {
+		last     roleSighting
+		res      PingResult
+		expected string
+	}{
+		"first sighting": {
+			res: PingResult{Role: RolePrimary, Timeline: 1, Time: after},
+		},
+		"unchanged": {
+			last: roleSighting{Role: RolePrimary, Timeline: 1, Time: before},
+			res:  PingResult{Role: RolePrimary, Timeline: 1, Time: after},
+		},
+		"promoted": {
+			last:     roleSighting{Role: RoleStandby, Timeline: 1, Time: before},
+			res:      PingResult{Role: RolePrimary, Timeline: 2, Time: after, Iteration: 5},
+			expected: `2023-03-30T15:41:17Z     event="role_changed" from="standby" to="primary" from_timeline=1 to_timeline=2 last_old=2023-03-30T15:41:14Z first_new=2023-03-30T15:41:17Z outage=3s i=5`, //nolint:lll
+		},
+		"new timeline": {
+			last:     roleSighting{Role: RoleStandby, Timeline: 2, Time: before},
+			res:      PingResult{Role: RoleStandby, Timeline: 3, Time: after, Iteration: 5, Target: "db"},
+			expected: `2023-03-30T15:41:17Z     target="db" event="timeline_changed" from="standby" to="standby" from_timeline=2 to_timeline=3 last_old=2023-03-30T15:41:14Z first_new=2023-03-30T15:41:17Z outage=3s i=5`, //nolint:lll
+		},
+	}
+	for desc, tc := range tests {
+		event, ok := roleChange(tc.last, tc.res)
+		if tc.expected == "" {
+			assert.False(t, ok, desc)
+			continue
+		}
+		assert.True(t, ok, desc)
+		assert.Equal(t, tc.expected, event.Text(), desc)
+	}
+}
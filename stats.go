@@ -0,0 +1,245 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// Series accumulates min/avg/max/mdev for a stream of durations without
+// keeping every sample around.
+type Series struct {
+	Count int
+	Min   time.Duration
+	Max   time.Duration
+
+	sum        float64
+	sumSquares float64
+}
+
+func (s *Series) Add(d time.Duration) {
+	if s.Count == 0 || d < s.Min {
+		s.Min = d
+	}
+	if s.Count == 0 || d > s.Max {
+		s.Max = d
+	}
+	s.Count++
+	s.sum += float64(d)
+	s.sumSquares += float64(d) * float64(d)
+}
+
+func (s *Series) Avg() time.Duration {
+	if s.Count == 0 {
+		return 0
+	}
+	return time.Duration(s.sum / float64(s.Count))
+}
+
+// Mdev is the standard deviation of the series, computed the same way as
+// iputils ping.
+func (s *Series) Mdev() time.Duration {
+	if s.Count == 0 {
+		return 0
+	}
+	avg := s.sum / float64(s.Count)
+	variance := s.sumSquares/float64(s.Count) - avg*avg
+	if variance < 0 {
+		variance = 0
+	}
+	return time.Duration(math.Sqrt(variance))
+}
+
+func (s *Series) String() string {
+	return fmt.Sprintf(
+		"%.3f/%.3f/%.3f/%.3f ms",
+		ms(s.Min),
+		ms(s.Avg()),
+		ms(s.Max),
+		ms(s.Mdev()),
+	)
+}
+
+type seriesJSON struct {
+	Count  int   `json:"count"`
+	MinNs  int64 `json:"min_ns"`
+	AvgNs  int64 `json:"avg_ns"`
+	MaxNs  int64 `json:"max_ns"`
+	MdevNs int64 `json:"mdev_ns"`
+}
+
+func (s *Series) JSON() seriesJSON {
+	return seriesJSON{
+		Count:  s.Count,
+		MinNs:  s.Min.Nanoseconds(),
+		AvgNs:  s.Avg().Nanoseconds(),
+		MaxNs:  s.Max.Nanoseconds(),
+		MdevNs: s.Mdev().Nanoseconds(),
+	}
+}
+
+func ms(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+type Stats struct {
+	Start       time.Time
+	Transmitted int
+	Received    int
+	Durations   Series
+	Phases      map[Phase]*Series
+	Outages     Outages
+	// Queries has a series per query when a ping runs several, in the order
+	// they run.
+	Queries []*QueryStats
+	// Last is the most recent ping.
+	Last PingResult
+}
+
+// QueryStats counts the runs of one query. Like the pings themselves, only
+// the runs that passed count towards its durations.
+type QueryStats struct {
+	Name      string
+	Run       int
+	Failed    int
+	Durations Series
+}
+
+func NewStats() *Stats {
+	return &Stats{
+		Start:  time.Now(),
+		Phases: make(map[Phase]*Series),
+	}
+}
+
+func (s *Stats) Add(res PingResult) {
+	s.Transmitted++
+	s.Last = res
+	for _, q := range res.Queries {
+		stats := s.query(q.Name)
+		stats.Run++
+		if q.Status != StatusOK && q.Status != StatusWarn {
+			stats.Failed++
+			continue
+		}
+		stats.Durations.Add(q.Duration)
+	}
+	if !res.Pass() {
+		return
+	}
+	s.Received++
+	s.Durations.Add(res.Duration)
+	for phase, d := range res.Phases {
+		series, ok := s.Phases[phase]
+		if !ok {
+			series = &Series{}
+			s.Phases[phase] = series
+		}
+		series.Add(d)
+	}
+}
+
+func (s *Stats) query(name string) *QueryStats {
+	for _, stats := range s.Queries {
+		if stats.Name == name {
+			return stats
+		}
+	}
+	stats := &QueryStats{Name: name}
+	s.Queries = append(s.Queries, stats)
+	return stats
+}
+
+func (s *Stats) Failed() int {
+	return s.Transmitted - s.Received
+}
+
+func (s *Stats) Loss() float64 {
+	if s.Transmitted == 0 {
+		return 0
+	}
+	return float64(s.Failed()) / float64(s.Transmitted) * 100
+}
+
+// Summary renders a closing block in the style of iputils ping.
+func (s *Stats) Summary(name string) []string {
+	lines := []string{
+		fmt.Sprintf("--- %s pgping statistics ---", name),
+		fmt.Sprintf(
+			"%d pings transmitted, %d received, %d failed, %.1f%% loss, time %s",
+			s.Transmitted,
+			s.Received,
+			s.Failed(),
+			s.Loss(),
+			time.Since(s.Start).Round(time.Millisecond),
+		),
+	}
+	if s.Durations.Count > 0 {
+		lines = append(lines, "duration min/avg/max/mdev = "+s.Durations.String())
+	}
+	for _, phase := range Phases {
+		if series, ok := s.Phases[phase]; ok {
+			lines = append(lines, fmt.Sprintf("%s min/avg/max/mdev = %s", phase, series))
+		}
+	}
+	for _, q := range s.Queries {
+		line := fmt.Sprintf("query %s: %d run, %d failed", q.Name, q.Run, q.Failed)
+		if q.Durations.Count > 0 {
+			line += ", min/avg/max/mdev = " + q.Durations.String()
+		}
+		lines = append(lines, line)
+	}
+	lines = append(lines, s.Outages.Summary(time.Now())...)
+	return lines
+}
+
+type statsJSON struct {
+	Type        string               `json:"type"`
+	Target      string               `json:"target"`
+	Transmitted int                  `json:"transmitted"`
+	Received    int                  `json:"received"`
+	Failed      int                  `json:"failed"`
+	LossPercent float64              `json:"loss_percent"`
+	ElapsedNs   int64                `json:"elapsed_ns"`
+	Duration    seriesJSON           `json:"duration"`
+	Phases      map[Phase]seriesJSON `json:"phases,omitempty"`
+	Queries     []queryStatsJSON     `json:"queries,omitempty"`
+	Outages     []outageJSON         `json:"outages"`
+}
+
+type queryStatsJSON struct {
+	Name     string     `json:"name"`
+	Run      int        `json:"run"`
+	Failed   int        `json:"failed"`
+	Duration seriesJSON `json:"duration"`
+}
+
+// JSON is the machine-readable equivalent of Summary.
+func (s *Stats) JSON(name string) statsJSON {
+	out := statsJSON{
+		Type:        "summary",
+		Target:      name,
+		Transmitted: s.Transmitted,
+		Received:    s.Received,
+		Failed:      s.Failed(),
+		LossPercent: s.Loss(),
+		ElapsedNs:   time.Since(s.Start).Nanoseconds(),
+		Duration:    s.Durations.JSON(),
+		Outages:     s.Outages.JSON(time.Now()),
+	}
+	if len(s.Phases) > 0 {
+		out.Phases = make(map[Phase]seriesJSON, len(s.Phases))
+		for phase, series := range s.Phases {
+			out.Phases[phase] = series.JSON()
+		}
+	}
+	for _, q := range s.Queries {
+		out.Queries = append(out.Queries, queryStatsJSON{
+			Name:     q.Name,
+			Run:      q.Run,
+			Failed:   q.Failed,
+			Duration: q.Durations.JSON(),
+		})
+	}
+	return out
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSeries(t *testing.T) {
+	tests := map[string]struct {
+		input []time.Duration
+		min   time.Duration
+		avg   time.Duration
+		max   time.Duration
+		mdev  time.Duration
+	}{
+		"empty": {},
+		"single": {
+			input: []time.Duration{5 * time.Millisecond},
+			min:   5 * time.Millisecond,
+			avg:   5 * time.Millisecond,
+			max:   5 * time.Millisecond,
+		},
+		"several": {
+			input: []time.Duration{2 * time.Millisecond, 4 * time.Millisecond, 4 * time.Millisecond, 6 * time.Millisecond},
+			min:   2 * time.Millisecond,
+			avg:   4 * time.Millisecond,
+			max:   6 * time.Millisecond,
+			mdev:  1414213 * time.Nanosecond,
+		},
+	}
+	for desc, tc := range tests {
+		var s Series
+		for _, d := range tc.input {
+			s.Add(d)
+		}
+		assert.Equal(t, len(tc.input), s.Count, desc)
+		assert.Equal(t, tc.min, s.Min, desc)
+		assert.Equal(t, tc.avg, s.Avg(), desc)
+		assert.Equal(t, tc.max, s.Max, desc)
+		assert.InDelta(t, tc.mdev, s.Mdev(), float64(time.Microsecond), desc)
+	}
+}
+
+func TestStats(t *testing.T) {
+	s := NewStats()
+	s.Add(PingResult{Status: StatusOK, Duration: 2 * time.Millisecond})
+	s.Add(PingResult{Status: StatusErr, Duration: 5 * time.Second})
+	s.Add(PingResult{Status: StatusOK, Duration: 4 * time.Millisecond})
+	s.Add(PingResult{Status: StatusOK, Duration: 6 * time.Millisecond})
+
+	assert.Equal(t, 4, s.Transmitted)
+	assert.Equal(t, 3, s.Received)
+	assert.Equal(t, 1, s.Failed())
+	assert.Equal(t, 25.0, s.Loss())
+	assert.Equal(t, 6*time.Millisecond, s.Durations.Max)
+
+	summary := s.Summary("db.example.com")
+	assert.Len(t, summary, 3)
+	assert.Equal(t, "--- db.example.com pgping statistics ---", summary[0])
+	assert.Contains(t, summary[1], "4 pings transmitted, 3 received, 1 failed, 25.0% loss")
+	assert.Equal(t, "duration min/avg/max/mdev = 2.000/4.000/6.000/1.633 ms", summary[2])
+}
+
+func TestStatsNoPings(t *testing.T) {
+	s := NewStats()
+	assert.Equal(t, 0.0, s.Loss())
+	assert.Len(t, s.Summary("db.example.com"), 2)
+}
+
+func TestStatsPhases(t *testing.T) {
+	s := NewStats()
+	s.Add(PingResult{
+		Status:   StatusOK,
+		Duration: 3 * time.Millisecond,
+		Phases: map[Phase]time.Duration{
+			PhaseConnect: 1 * time.Millisecond,
+			PhaseQuery:   2 * time.Millisecond,
+		},
+	})
+	s.Add(PingResult{
+		Status:   StatusErr,
+		Duration: 5 * time.Second,
+		Phases: map[Phase]time.Duration{
+			PhaseConnect: 5 * time.Second,
+		},
+	})
+
+	assert.Equal(t, 1, s.Phases[PhaseConnect].Count)
+	assert.Equal(t, 1*time.Millisecond, s.Phases[PhaseConnect].Max)
+	assert.NotContains(t, s.Phases, PhaseTLS)
+
+	summary := s.Summary("db.example.com")
+	assert.Equal(t, []string{
+		"duration min/avg/max/mdev = 3.000/3.000/3.000/0.000 ms",
+		"connect min/avg/max/mdev = 1.000/1.000/1.000/0.000 ms",
+		"query min/avg/max/mdev = 2.000/2.000/2.000/0.000 ms",
+	}, summary[2:])
+}
+
+func TestStatsQueries(t *testing.T) {
+	s := NewStats()
+	s.Add(PingResult{
+		Status:   StatusOK,
+		Duration: 3 * time.Millisecond,
+		Queries: []QueryStatus{
+			{Name: "locks", Status: StatusOK, Duration: 1 * time.Millisecond},
+			{Name: "jobs", Status: StatusOK, Duration: 2 * time.Millisecond},
+		},
+	})
+	s.Add(PingResult{
+		Status:   StatusFail,
+		Duration: 3 * time.Millisecond,
+		Queries: []QueryStatus{
+			{Name: "locks", Status: StatusOK, Duration: 3 * time.Millisecond},
+			{Name: "jobs", Status: StatusFail, Duration: 2 * time.Millisecond},
+		},
+	})
+
+	summary := s.Summary("db.example.com")
+	assert.Equal(t, []string{
+		"query locks: 2 run, 0 failed, min/avg/max/mdev = 1.000/2.000/3.000/1.000 ms",
+		"query jobs: 2 run, 1 failed, min/avg/max/mdev = 2.000/2.000/2.000/0.000 ms",
+	}, summary[3:])
+
+	out := s.JSON("db.example.com")
+	assert.Equal(t, []queryStatsJSON{
+		{Name: "locks", Run: 2, Duration: s.Queries[0].Durations.JSON()},
+		{Name: "jobs", Run: 2, Failed: 1, Duration: s.Queries[1].Durations.JSON()},
+	}, out.Queries)
+}
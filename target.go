@@ -1,13 +1,21 @@
 package main
 
 import (
+	"fmt"
+	"net"
+	"net/url"
 	"os"
 	"os/user"
 	"path/filepath"
+	"regexp"
 	"runtime"
+	"sort"
 	"strconv"
 	"strings"
+	"unicode"
 
+	"github.com/jackc/pgpassfile"
+	"github.com/jackc/pgservicefile"
 	"github.com/jackc/pgx/v5"
 	"github.com/jdxcode/netrc"
 )
@@ -20,11 +28,308 @@ type Target struct {
 	Password string
 	AppName  string
 	SSLMode  string
+
+	// SSLRootCert, SSLCert, SSLKey, SSLPassword, SSLCRL and SSLSNI are the
+	// libpq TLS parameters of the same names.
+	SSLRootCert string
+	SSLCert     string
+	SSLKey      string
+	SSLPassword string
+	SSLCRL      string
+	SSLSNI      string
+
+	// Service is the pg_service.conf service the target was built from and
+	// ServiceFile the file it was found in.
+	Service     string
+	ServiceFile string
+
+	// Params holds every other connection parameter (connect_timeout,
+	// target_session_attrs, options, runtime parameters, ...) to be passed
+	// through to pgx as is.
+	Params map[string]string
+}
+
+var keywordValueRegexp = regexp.MustCompile(`^\s*[A-Za-z_]+\s*=`)
+
+// takeEnvService returns $PGSERVICE and unsets it. Target.FromService
+// resolves it like libpq, also in $PGSYSCONFDIR, and ToConnConfig hands it to
+// pgx along with the file it was found in. Left set, every other
+// pgx.ParseConfig would look it up again, only in the user's service file,
+// and fail when it's defined elsewhere.
+func takeEnvService() string {
+	service := os.Getenv("PGSERVICE")
+	os.Unsetenv("PGSERVICE")
+	return service
+}
+
+// isKeywordValue reports whether s is a libpq keyword/value connection string
+// such as `host=db port=5432`.
+func isKeywordValue(s string) bool {
+	return !strings.Contains(s, "://") && keywordValueRegexp.MatchString(s)
+}
+
+// splitService pulls the service parameter out of a connection string so that
+// it can be resolved by Target.FromService rather than by pgx.
+func splitService(s string) (string, string) {
+	if isKeywordValue(s) {
+		pairs, err := keywordValuePairs(s)
+		if err != nil {
+			// leave it to pgx to report
+			return "", s
+		}
+		service := ""
+		rest := make([]string, 0, len(pairs))
+		for _, pair := range pairs {
+			if pair.key == "service" {
+				service = pair.value
+				continue
+			}
+			rest = append(rest, pair.key+"="+quoteKeywordValue(pair.value))
+		}
+		return service, strings.Join(rest, " ")
+	}
+	base, rawQuery, found := strings.Cut(s, "?")
+	if !found {
+		return "", s
+	}
+	query, err := url.ParseQuery(rawQuery)
+	if err != nil || !query.Has("service") {
+		return "", s
+	}
+	service := query.Get("service")
+	query.Del("service")
+	if len(query) == 0 {
+		return service, base
+	}
+	return service, base + "?" + query.Encode()
+}
+
+// parseKeywordValue parses a libpq keyword/value connection string, including
+// single-quoted values and backslash escapes.
+func parseKeywordValue(s string) (map[string]string, error) {
+	pairs, err := keywordValuePairs(s)
+	if err != nil {
+		return nil, err
+	}
+	params := make(map[string]string, len(pairs))
+	for _, pair := range pairs {
+		params[pair.key] = pair.value
+	}
+	return params, nil
+}
+
+type keywordValuePair struct {
+	key   string
+	value string
+}
+
+// keywordValuePairs splits a libpq keyword/value connection string into its
+// parameters, in order.
+func keywordValuePairs(s string) ([]keywordValuePair, error) {
+	pairs := []keywordValuePair{}
+	i := 0
+	skipSpace := func() {
+		for i < len(s) && unicode.IsSpace(rune(s[i])) {
+			i++
+		}
+	}
+	for {
+		skipSpace()
+		if i >= len(s) {
+			return pairs, nil
+		}
+		start := i
+		for i < len(s) && s[i] != '=' && !unicode.IsSpace(rune(s[i])) {
+			i++
+		}
+		key := s[start:i]
+		skipSpace()
+		if i >= len(s) || s[i] != '=' {
+			return nil, fmt.Errorf("missing \"=\" after `%s` in connection string", key)
+		}
+		i++
+		skipSpace()
+		var value strings.Builder
+		if i < len(s) && s[i] == '\'' {
+			i++
+			for {
+				if i >= len(s) {
+					return nil, fmt.Errorf("unterminated quoted value for `%s` in connection string", key)
+				}
+				if s[i] == '\\' && i+1 < len(s) {
+					i++
+				} else if s[i] == '\'' {
+					i++
+					break
+				}
+				value.WriteByte(s[i])
+				i++
+			}
+		} else {
+			for i < len(s) && !unicode.IsSpace(rune(s[i])) {
+				if s[i] == '\\' && i+1 < len(s) {
+					i++
+				}
+				value.WriteByte(s[i])
+				i++
+			}
+		}
+		pairs = append(pairs, keywordValuePair{key: key, value: value.String()})
+	}
+}
+
+// connStringParams returns the raw parameters of a keyword/value or URL
+// connection string.
+func connStringParams(s string) (map[string]string, error) {
+	if isKeywordValue(s) {
+		return parseKeywordValue(s)
+	}
+	parsed, err := url.Parse(s)
+	if err != nil {
+		return nil, err
+	}
+	params := make(map[string]string)
+	for key, values := range parsed.Query() {
+		params[key] = values[0]
+	}
+	return params, nil
+}
+
+// redactConnString masks the passwords in the connection string s so that it
+// can be logged. One that doesn't parse is masked whole, as there's no telling
+// where its password is.
+func redactConnString(s string) string {
+	const mask = "xxxxx"
+	secret := func(key string) bool {
+		return key == "password" || key == "sslpassword"
+	}
+	if isKeywordValue(s) {
+		pairs, err := keywordValuePairs(s)
+		if err != nil {
+			return mask
+		}
+		redacted := make([]string, 0, len(pairs))
+		for _, pair := range pairs {
+			value := pair.value
+			if secret(pair.key) {
+				value = mask
+			}
+			redacted = append(redacted, pair.key+"="+quoteKeywordValue(value))
+		}
+		return strings.Join(redacted, " ")
+	}
+	if s == "" {
+		return s
+	}
+	prefix := ""
+	if !strings.HasPrefix(s, "postgres://") && !strings.HasPrefix(s, "postgresql://") {
+		prefix = "postgres://"
+	}
+	parsed, err := url.Parse(prefix + s)
+	if err != nil {
+		return mask
+	}
+	query := parsed.Query()
+	for key := range query {
+		if secret(key) {
+			query.Set(key, mask)
+			parsed.RawQuery = query.Encode()
+		}
+	}
+	return strings.TrimPrefix(parsed.Redacted(), prefix)
+}
+
+// defaultPort is the port libpq connects to when none is given.
+const defaultPort = 5432
+
+type hostPort struct {
+	Host string
+	Port int
+}
+
+// hosts splits Host, which like libpq's host parameter may be a
+// comma-separated list, into its entries. An entry without its own port
+// gets Port.
+func (t *Target) hosts() []hostPort {
+	entries := strings.Split(t.Host, ",")
+	hosts := make([]hostPort, 0, len(entries))
+	for _, entry := range entries {
+		h := hostPort{Host: entry, Port: t.Port}
+		if host, port, err := net.SplitHostPort(entry); err == nil {
+			if portInt, err := strconv.Atoi(port); err == nil {
+				h = hostPort{Host: host, Port: portInt}
+			}
+		}
+		hosts = append(hosts, h)
+	}
+	return hosts
+}
+
+// connConfigServers lists the distinct host:port pairs pgx will try for
+// connConfig, in order.
+func connConfigServers(connConfig *pgx.ConnConfig) []string {
+	servers := []string{}
+	seen := make(map[string]bool)
+	add := func(host string, port uint16) {
+		server := net.JoinHostPort(host, strconv.Itoa(int(port)))
+		if !seen[server] {
+			seen[server] = true
+			servers = append(servers, server)
+		}
+	}
+	add(connConfig.Host, connConfig.Port)
+	for _, fallback := range connConfig.Fallbacks {
+		add(fallback.Host, fallback.Port)
+	}
+	return servers
+}
+
+// targetLabel names the target connConfig connects to as user@host:port/dbname,
+// which unlike its connection string carries no password.
+func targetLabel(connConfig *pgx.ConnConfig) string {
+	return fmt.Sprintf("%s@%s/%s", connConfig.User, strings.Join(connConfigServers(connConfig), ","), connConfig.Database)
+}
+
+// sslParams lists the libpq TLS parameters besides sslmode that Target
+// carries.
+var sslParams = []string{"sslrootcert", "sslcert", "sslkey", "sslpassword", "sslcrl", "sslsni"}
+
+// sslParam points at the field holding the TLS parameter key, or returns nil
+// if key isn't one of sslParams.
+func (t *Target) sslParam(key string) *string {
+	switch key {
+	case "sslrootcert":
+		return &t.SSLRootCert
+	case "sslcert":
+		return &t.SSLCert
+	case "sslkey":
+		return &t.SSLKey
+	case "sslpassword":
+		return &t.SSLPassword
+	case "sslcrl":
+		return &t.SSLCRL
+	case "sslsni":
+		return &t.SSLSNI
+	}
+	return nil
+}
+
+// setSSLParam sets the TLS parameter key to value, taking care not to log
+// sslpassword.
+func (t *Target) setSSLParam(source, key, value string) {
+	if key == "sslpassword" {
+		logger.Debug("setting sslpassword", "source", source)
+	} else {
+		logger.Debug("setting "+key, "source", source, key, value)
+	}
+	*t.sslParam(key) = value
 }
 
 func (t *Target) FromConnString(s string) error {
-	if !strings.Contains(s, "postgres://") {
-		debugf("Target.FromConnString: adding connstring prefix to `%s`", s)
+	if isKeywordValue(s) {
+		logger.Debug("parsing keyword/value connstring", "source", "connstring")
+	} else if !strings.HasPrefix(s, "postgres://") && !strings.HasPrefix(s, "postgresql://") {
+		logger.Debug("adding connstring prefix", "source", "connstring", "connstring", redactConnString(s))
 		s = "postgres://" + s
 	}
 	empty, err := pgx.ParseConfig("postgres://")
@@ -35,36 +340,64 @@ func (t *Target) FromConnString(s string) error {
 	if err != nil {
 		return err
 	}
-	if parsed.Host != empty.Host || t.Host == "" {
-		debugf("Target.FromConnString: setting host to `%s`", parsed.Host)
+	if servers := connConfigServers(parsed); len(servers) > 1 {
+		host := strings.Join(servers, ",")
+		logger.Debug("setting host", "source", "connstring", "host", host)
+		t.Host = host
+	} else if parsed.Host != empty.Host || t.Host == "" {
+		logger.Debug("setting host", "source", "connstring", "host", parsed.Host)
 		t.Host = parsed.Host
 	}
 	if parsed.Port != empty.Port || t.Port == 0 {
-		debugf("Target.FromConnString: setting host to `%d`", parsed.Port)
+		logger.Debug("setting port", "source", "connstring", "port", parsed.Port)
 		t.Port = int(parsed.Port)
 	}
 	if parsed.Database != empty.Database || t.Database == "" {
-		debugf("Target.FromConnString: setting database to `%s`", parsed.Database)
+		logger.Debug("setting database", "source", "connstring", "database", parsed.Database)
 		t.Database = parsed.Database
 	}
 	if parsed.User != empty.User || t.User == "" {
-		debugf("Target.FromConnString: setting user to `%s`", parsed.User)
+		logger.Debug("setting user", "source", "connstring", "user", parsed.User)
 		t.User = parsed.User
 	}
 	if parsed.Password != empty.Password || t.Password == "" {
-		debugf("Target.FromConnString: setting password to `%s`", parsed.Password)
+		logger.Debug("setting password", "source", "connstring")
 		t.Password = parsed.Password
 	}
+	params, err := connStringParams(s)
+	if err != nil {
+		return err
+	}
+	for key, value := range params {
+		switch key {
+		case "host", "port", "dbname", "database", "user", "password", "service", "servicefile":
+			// already handled above, or by Target.FromService
+		case "sslmode":
+			logger.Debug("setting sslmode", "source", "connstring", "sslmode", value)
+			t.SSLMode = value
+		case "application_name":
+			logger.Debug("setting appname", "source", "connstring", "appname", value)
+			t.AppName = value
+		case "sslrootcert", "sslcert", "sslkey", "sslpassword", "sslcrl", "sslsni":
+			t.setSSLParam("connstring", key, value)
+		default:
+			logger.Debug("setting parameter", "source", "connstring", "key", key, "value", value)
+			if t.Params == nil {
+				t.Params = make(map[string]string)
+			}
+			t.Params[key] = value
+		}
+	}
 	return nil
 }
 
 func (t *Target) FromEnv(getenv func(string) string) error {
 	if host := getenv("PGHOST"); host != "" {
-		debugf("Target.FromEnv: setting host to `%s`", host)
+		logger.Debug("setting host", "source", "env", "host", host)
 		t.Host = host
 	}
 	if port := getenv("PGPORT"); port != "" {
-		debugf("Target.FromEnv: setting port to `%s`", port)
+		logger.Debug("setting port", "source", "env", "port", port)
 		portInt, err := strconv.Atoi(port)
 		if err != nil {
 			return err
@@ -72,36 +405,41 @@ func (t *Target) FromEnv(getenv func(string) string) error {
 		t.Port = portInt
 	}
 	if database := getenv("PGDATABASE"); database != "" {
-		debugf("Target.FromEnv: setting database to `%s`", database)
+		logger.Debug("setting database", "source", "env", "database", database)
 		t.Database = database
 	}
 	if user := getenv("PGUSER"); user != "" {
-		debugf("Target.FromEnv: setting user to `%s`", user)
+		logger.Debug("setting user", "source", "env", "user", user)
 		t.User = user
 	}
 	if password := getenv("PGPASSWORD"); password != "" {
-		debugf("Target.FromEnv: setting password to `%s`", password)
+		logger.Debug("setting password", "source", "env")
 		t.Password = password
 	}
 	if appName := getenv("PGAPPNAME"); appName != "" {
-		debugf("Target.FromEnv: setting appname to `%s`", appName)
+		logger.Debug("setting appname", "source", "env", "appname", appName)
 		t.AppName = appName
 	}
 	if sslMode := getenv("PGSSLMODE"); sslMode != "" {
-		debugf("Target.FromEnv: setting sslmode to `%s`", sslMode)
-		t.AppName = sslMode
+		logger.Debug("setting sslmode", "source", "env", "sslmode", sslMode)
+		t.SSLMode = sslMode
+	}
+	for _, key := range sslParams {
+		if value := getenv("PG" + strings.ToUpper(key)); value != "" {
+			t.setSSLParam("env", key, value)
+		}
 	}
 	return nil
 }
 
 func (t *Target) FromNetrc(path string) error {
 	if path == "" {
-		debugln("Target.FromNetrc: netrc path not provided, finding suitable netrc")
+		logger.Debug("netrc path not provided, finding suitable netrc", "source", "netrc")
 		if env := os.Getenv("NETRC"); env != "" {
-			debugf("Target.FromNetrc: using $NETRC environment variable")
+			logger.Debug("using $NETRC environment variable", "source", "netrc")
 			path = env
 		} else {
-			debugf("Target.FromNetrc: $NETRC not set, using netrc in home directory")
+			logger.Debug("$NETRC not set, using netrc in home directory", "source", "netrc")
 			base := ".netrc"
 			if runtime.GOOS == "windows" {
 				base = "_netrc"
@@ -113,23 +451,24 @@ func (t *Target) FromNetrc(path string) error {
 			path = filepath.Join(usr.HomeDir, base)
 		}
 	}
-	debugf("Target.FromNetrc: using netrc at `%s`", path)
+	logger.Debug("using netrc", "source", "netrc", "path", path)
 	stat, err := os.Stat(path)
 	if os.IsNotExist(err) {
-		debugf("Target.FromNetrc: netrc at `%s` doesn't exist; skipping netrc configuration", path)
+		logger.Debug("netrc doesn't exist; skipping netrc configuration", "source", "netrc", "path", path)
 		return nil
 	}
 	if stat.IsDir() {
-		debugf("Target.FromNetrc: netrc at `%s` is a directory; skipping netrc configuration", path)
+		logger.Debug("netrc is a directory; skipping netrc configuration", "source", "netrc", "path", path)
 		return nil
 	}
 	n, err := netrc.Parse(path)
 	if err != nil {
 		return err
 	}
-	machine := n.Machine(t.Host)
+	host := t.hosts()[0].Host
+	machine := n.Machine(host)
 	if machine == nil {
-		debugf("Target.FromNetrc: netrc doesn't contain a machine entry for `%s`; skipping netrc configuration", t.Host)
+		logger.Debug("netrc doesn't contain a machine entry; skipping netrc configuration", "source", "netrc", "host", host)
 		return nil
 	}
 	empty, err := pgx.ParseConfig("postgres://")
@@ -138,26 +477,199 @@ func (t *Target) FromNetrc(path string) error {
 	}
 	if t.User == "" || t.User == empty.User {
 		if username := machine.Get("login"); username != "" {
-			debugf("Target.FromNetrc: setting user to `%s`", username)
+			logger.Debug("setting user", "source", "netrc", "user", username)
 			t.User = username
 		}
 	}
 	if t.Password == "" {
 		if password := machine.Get("password"); password != "" {
-			debugf("Target.FromNetrc: setting password to `%s`", password)
+			logger.Debug("setting password", "source", "netrc")
 			t.Password = password
 		}
 	}
 	return nil
 }
 
+// FromPgpass fills in the password from a PostgreSQL password file, following
+// the same lookup and matching rules as libpq. It never overrides a password
+// that was already set by another source.
+func (t *Target) FromPgpass(path string) error {
+	if t.Password != "" {
+		logger.Debug("password already set; skipping pgpass configuration", "source", "pgpass")
+		return nil
+	}
+	if path == "" {
+		logger.Debug("pgpass path not provided, finding suitable pgpass", "source", "pgpass")
+		if env := os.Getenv("PGPASSFILE"); env != "" {
+			logger.Debug("using $PGPASSFILE environment variable", "source", "pgpass")
+			path = env
+		} else if runtime.GOOS == "windows" {
+			logger.Debug("$PGPASSFILE not set, using pgpass.conf in %APPDATA%", "source", "pgpass")
+			path = filepath.Join(os.Getenv("APPDATA"), "postgresql", "pgpass.conf")
+		} else {
+			logger.Debug("$PGPASSFILE not set, using pgpass in home directory", "source", "pgpass")
+			usr, err := user.Current()
+			if err != nil {
+				return err
+			}
+			path = filepath.Join(usr.HomeDir, ".pgpass")
+		}
+	}
+	logger.Debug("using pgpass", "source", "pgpass", "path", path)
+	stat, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		logger.Debug("pgpass doesn't exist; skipping pgpass configuration", "source", "pgpass", "path", path)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if !stat.Mode().IsRegular() {
+		logger.Debug("pgpass is not a regular file; skipping pgpass configuration", "source", "pgpass", "path", path)
+		return nil
+	}
+	if runtime.GOOS != "windows" && stat.Mode().Perm()&0o077 != 0 {
+		logger.Warn(
+			"pgpass has group or world access; permissions should be u=rw (0600) or less; skipping pgpass configuration",
+			"source", "pgpass",
+			"path", path,
+		)
+		return nil
+	}
+	passfile, err := pgpassfile.ReadPassfile(path)
+	if err != nil {
+		return err
+	}
+	empty, err := pgx.ParseConfig("postgres://")
+	if err != nil {
+		return err
+	}
+	// like pgx, only the first of several hosts is looked up
+	primary := t.hosts()[0]
+	host := primary.Host
+	if host == "" || strings.HasPrefix(host, "/") {
+		host = "localhost"
+	}
+	port := primary.Port
+	if port == 0 {
+		port = int(empty.Port)
+	}
+	username := t.User
+	if username == "" {
+		username = empty.User
+	}
+	database := t.Database
+	if database == "" {
+		database = username
+	}
+	password := passfile.FindPassword(host, strconv.Itoa(port), database, username)
+	if password == "" {
+		logger.Debug(
+			"pgpass doesn't contain a matching entry; skipping pgpass configuration",
+			"source", "pgpass",
+			"host", host,
+			"port", port,
+			"database", database,
+			"user", username,
+		)
+		return nil
+	}
+	logger.Debug("setting password", "source", "pgpass")
+	t.Password = password
+	return nil
+}
+
+// FromService merges the host, port, database, user, password and sslmode of
+// the named service from the user's service file or, failing that, the
+// system-wide one in $PGSYSCONFDIR, the same way libpq looks them up.
+func (t *Target) FromService(name string, getenv func(string) string) error {
+	if name == "" {
+		return nil
+	}
+	paths := make([]string, 0, 2)
+	if env := getenv("PGSERVICEFILE"); env != "" {
+		logger.Debug("using $PGSERVICEFILE environment variable", "source", "service")
+		paths = append(paths, env)
+	} else {
+		usr, err := user.Current()
+		if err != nil {
+			return err
+		}
+		paths = append(paths, filepath.Join(usr.HomeDir, ".pg_service.conf"))
+	}
+	if sysconfdir := getenv("PGSYSCONFDIR"); sysconfdir != "" {
+		paths = append(paths, filepath.Join(sysconfdir, "pg_service.conf"))
+	}
+	for _, path := range paths {
+		logger.Debug("looking for service", "source", "service", "service", name, "path", path)
+		stat, err := os.Stat(path)
+		if os.IsNotExist(err) {
+			logger.Debug("service file doesn't exist", "source", "service", "path", path)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if stat.IsDir() {
+			logger.Debug("service file is a directory", "source", "service", "path", path)
+			continue
+		}
+		servicefile, err := pgservicefile.ReadServicefile(path)
+		if err != nil {
+			return err
+		}
+		service, err := servicefile.GetService(name)
+		if err != nil {
+			logger.Debug("service file doesn't define service", "source", "service", "service", name, "path", path)
+			continue
+		}
+		t.Service = name
+		t.ServiceFile = path
+		if host := service.Settings["host"]; host != "" {
+			logger.Debug("setting host", "source", "service", "host", host)
+			t.Host = host
+		}
+		if port := service.Settings["port"]; port != "" {
+			logger.Debug("setting port", "source", "service", "port", port)
+			portInt, err := strconv.Atoi(port)
+			if err != nil {
+				return err
+			}
+			t.Port = portInt
+		}
+		if database := service.Settings["dbname"]; database != "" {
+			logger.Debug("setting database", "source", "service", "database", database)
+			t.Database = database
+		}
+		if username := service.Settings["user"]; username != "" {
+			logger.Debug("setting user", "source", "service", "user", username)
+			t.User = username
+		}
+		if password := service.Settings["password"]; password != "" {
+			logger.Debug("setting password", "source", "service")
+			t.Password = password
+		}
+		if sslMode := service.Settings["sslmode"]; sslMode != "" {
+			logger.Debug("setting sslmode", "source", "service", "sslmode", sslMode)
+			t.SSLMode = sslMode
+		}
+		for _, key := range sslParams {
+			if value := service.Settings[key]; value != "" {
+				t.setSSLParam("service", key, value)
+			}
+		}
+		return nil
+	}
+	return fmt.Errorf("definition of service `%s` not found", name)
+}
+
 func (t *Target) FromFlags() error {
 	if pgHost != nil && *pgHost != "" {
-		debugf("Target.FromFlags: setting host to `%s`", *pgHost)
+		logger.Debug("setting host", "source", "flags", "host", *pgHost)
 		t.Host = *pgHost
 	}
 	if pgPort != nil && *pgPort != "" {
-		debugf("Target.FromFlags: setting port to `%s`", *pgPort)
+		logger.Debug("setting port", "source", "flags", "port", *pgPort)
 		port, err := strconv.Atoi(*pgPort)
 		if err != nil {
 			return err
@@ -165,55 +677,170 @@ func (t *Target) FromFlags() error {
 		t.Port = port
 	}
 	if pgDatabase != nil && *pgDatabase != "" {
-		debugf("Target.FromFlags: setting database to `%s`", *pgDatabase)
+		logger.Debug("setting database", "source", "flags", "database", *pgDatabase)
 		t.Database = *pgDatabase
 	}
 	if pgUser != nil && *pgUser != "" {
-		debugf("Target.FromFlags: setting user to `%s`", *pgUser)
+		logger.Debug("setting user", "source", "flags", "user", *pgUser)
 		t.User = *pgUser
 	}
 	if pgPassword != nil && *pgPassword != "" {
-		debugf("Target.FromFlags: setting password to `%s`", *pgPassword)
+		logger.Debug("setting password", "source", "flags")
 		t.Password = *pgPassword
 	}
 	if pgAppName != nil && *pgAppName != "" {
-		debugf("Target.FromFlags: setting appname to `%s`", *pgAppName)
+		logger.Debug("setting appname", "source", "flags", "appname", *pgAppName)
 		t.AppName = *pgAppName
 	}
+	if pgTargetSessionAttrs != nil && *pgTargetSessionAttrs != "" {
+		logger.Debug("setting target_session_attrs", "source", "flags", "target_session_attrs", *pgTargetSessionAttrs)
+		if t.Params == nil {
+			t.Params = make(map[string]string)
+		}
+		t.Params["target_session_attrs"] = *pgTargetSessionAttrs
+	}
 	if pgSSLMode != nil && *pgSSLMode != "" {
-		debugf("Target.FromFlags: setting sslmode to `%s`", *pgSSLMode)
+		logger.Debug("setting sslmode", "source", "flags", "sslmode", *pgSSLMode)
 		t.SSLMode = *pgSSLMode
 	}
+	for _, key := range sslParams {
+		if flag := pgSSLFlags[key]; flag != nil && *flag != "" {
+			t.setSSLParam("flags", key, *flag)
+		}
+	}
 	return nil
 }
 
+// FromConfig merges the connection parameters set in config.
+func (t *Target) FromConfig(config *TargetConfig) error {
+	if config.Host != "" {
+		logger.Debug("setting host", "source", "config", "host", config.Host)
+		t.Host = config.Host
+	}
+	if config.Port != 0 {
+		logger.Debug("setting port", "source", "config", "port", config.Port)
+		t.Port = config.Port
+	}
+	if config.Database != "" {
+		logger.Debug("setting database", "source", "config", "database", config.Database)
+		t.Database = config.Database
+	}
+	if config.User != "" {
+		logger.Debug("setting user", "source", "config", "user", config.User)
+		t.User = config.User
+	}
+	if config.Password != "" {
+		logger.Debug("setting password", "source", "config")
+		t.Password = config.Password
+	}
+	if config.AppName != "" {
+		logger.Debug("setting appname", "source", "config", "appname", config.AppName)
+		t.AppName = config.AppName
+	}
+	if config.SSLMode != "" {
+		logger.Debug("setting sslmode", "source", "config", "sslmode", config.SSLMode)
+		t.SSLMode = config.SSLMode
+	}
+	values := map[string]string{
+		"sslrootcert": config.SSLRootCert,
+		"sslcert":     config.SSLCert,
+		"sslkey":      config.SSLKey,
+		"sslpassword": config.SSLPassword,
+		"sslcrl":      config.SSLCRL,
+		"sslsni":      config.SSLSNI,
+	}
+	for _, key := range sslParams {
+		if value := values[key]; value != "" {
+			t.setSSLParam("config", key, value)
+		}
+	}
+	for key, value := range config.Params {
+		logger.Debug("setting parameter", "source", "config", "key", key, "value", value)
+		if t.Params == nil {
+			t.Params = make(map[string]string)
+		}
+		t.Params[key] = value
+	}
+	return nil
+}
+
+// quoteKeywordValue quotes value for a keyword/value connection string.
+func quoteKeywordValue(value string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(value) + "'"
+}
+
+// ToConnConfig turns the target into a pgx.ConnConfig. It goes through a
+// keyword/value connection string, where every value can be quoted, so that
+// socket directories and names with any characters in them come through
+// unchanged.
 func (t *Target) ToConnConfig() (*pgx.ConnConfig, error) {
 	var connString strings.Builder
-	connString.WriteString("postgres://")
-	if t.User != "" {
-		connString.WriteString(t.User)
-		connString.WriteString("@")
+	param := func(key, value string) {
+		if connString.Len() > 0 {
+			connString.WriteString(" ")
+		}
+		connString.WriteString(key)
+		connString.WriteString("=")
+		connString.WriteString(quoteKeywordValue(value))
 	}
 	if t.Host != "" {
-		connString.WriteString(t.Host)
+		hosts := t.hosts()
+		hostList := make([]string, 0, len(hosts))
+		portList := make([]string, 0, len(hosts))
+		ports := false
+		for _, h := range hosts {
+			hostList = append(hostList, h.Host)
+			portList = append(portList, strconv.Itoa(h.Port))
+			ports = ports || h.Port != 0
+		}
+		param("host", strings.Join(hostList, ","))
+		if ports {
+			// pgx wants a port for every host once any has one
+			for i, h := range hosts {
+				if h.Port == 0 {
+					portList[i] = strconv.Itoa(defaultPort)
+				}
+			}
+			param("port", strings.Join(portList, ","))
+		}
+	} else if t.Port != 0 {
+		param("port", strconv.Itoa(t.Port))
 	}
-	if t.Port != 0 {
-		connString.WriteString(":")
-		connString.WriteString(strconv.Itoa(t.Port))
+	if t.User != "" {
+		param("user", t.User)
 	}
 	if t.Database != "" {
-		connString.WriteString("/")
-		connString.WriteString(t.Database)
+		param("dbname", t.Database)
 	}
-	connString.WriteString("?application_name=")
 	if t.AppName == "" {
-		connString.WriteString("pgping/" + VERSION)
+		param("application_name", "pgping/"+VERSION)
 	} else {
-		connString.WriteString(t.AppName)
+		param("application_name", t.AppName)
 	}
+	// the password file has already been consulted by Target.FromPgpass, which
+	// unlike pgx refuses files that other users can read.
+	param("passfile", "")
 	if t.SSLMode != "" {
-		connString.WriteString("&sslmode=")
-		connString.WriteString(t.SSLMode)
+		param("sslmode", t.SSLMode)
+	}
+	for _, key := range sslParams {
+		// pgx doesn't know sslcrl; it is applied below instead.
+		if value := *t.sslParam(key); value != "" && key != "sslcrl" {
+			param(key, value)
+		}
+	}
+	// hand the service over to pgx too, along with the file it was found in.
+	if t.Service != "" {
+		param("service", t.Service)
+		param("servicefile", t.ServiceFile)
+	}
+	keys := make([]string, 0, len(t.Params))
+	for key := range t.Params {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		param(key, t.Params[key])
 	}
 	connConfig, err := pgx.ParseConfig(connString.String())
 	if err != nil {
@@ -222,5 +849,20 @@ func (t *Target) ToConnConfig() (*pgx.ConnConfig, error) {
 	if t.Password != "" {
 		connConfig.Password = t.Password
 	}
+	// pgx passes parameters it doesn't know on to the server, which would
+	// reject an sslcrl picked up from a service file.
+	crl := t.SSLCRL
+	if value, ok := connConfig.RuntimeParams["sslcrl"]; ok {
+		delete(connConfig.RuntimeParams, "sslcrl")
+		if crl == "" {
+			crl = value
+		}
+	}
+	if crl != "" {
+		err = applyCRL(connConfig, crl)
+		if err != nil {
+			return connConfig, err
+		}
+	}
 	return connConfig, nil
 }
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgconn/ctxwatch"
+)
+
+// cancelGrace is how long a query that timed out has to be cancelled by the
+// server before the connection is cut from under it.
+const cancelGrace = time.Second
+
+// Timeouts bound the phases of a ping within Timeout, so that an unreachable
+// server, a slow query and a hanging close can be told apart. Zero leaves a
+// phase bounded by Timeout alone.
+type Timeouts struct {
+	Connect time.Duration
+	Query   time.Duration
+	Close   time.Duration
+}
+
+// deadlineExceeded is the cause of a context whose deadline was hit, naming
+// the flag the deadline came from.
+type deadlineExceeded struct {
+	flag    string
+	timeout time.Duration
+}
+
+func (e *deadlineExceeded) Error() string {
+	return fmt.Sprintf("%s of %s exceeded", e.flag, e.timeout)
+}
+
+// withTimeout is context.WithTimeout, but remembers which flag the deadline
+// came from. A timeout of zero adds no deadline.
+func withTimeout(ctx context.Context, flag string, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeoutCause(ctx, timeout, &deadlineExceeded{flag: flag, timeout: timeout})
+}
+
+// deadlineHit names the flag whose deadline made an operation run under ctx
+// fail with err, or returns "" if it failed for some other reason.
+func (p *Pinger) deadlineHit(ctx context.Context, err error) string {
+	if err == nil {
+		return ""
+	}
+	// dials notice a deadline has passed slightly before ctx does
+	if deadline, ok := ctx.Deadline(); ok && !time.Now().Before(deadline) {
+		<-ctx.Done()
+	}
+	var exceeded *deadlineExceeded
+	if errors.As(context.Cause(ctx), &exceeded) {
+		return exceeded.flag
+	}
+	// query_canceled is the statement_timeout pgping set firing before the
+	// query timeout does, unless someone cancelled the query by hand. Only the
+	// translated message tells those apart, so without a statement_timeout of
+	// our own it's taken for a plain error.
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "57014" && p.statementTimeoutQuery() != "" {
+		return "query-timeout"
+	}
+	return ""
+}
+
+// statementTimeoutQuery is the query that sets statement_timeout to the query
+// timeout on new connections, or "" when there is no query timeout or the
+// target sets statement_timeout itself.
+func (p *Pinger) statementTimeoutQuery() string {
+	if p.Timeouts.Query <= 0 {
+		return ""
+	}
+	if _, ok := p.ConnConfig.RuntimeParams["statement_timeout"]; ok {
+		return ""
+	}
+	return fmt.Sprintf("SET statement_timeout = %d", max(p.Timeouts.Query.Milliseconds(), 1))
+}
+
+// enforceQueryTimeout has the server cancel statements that run past the
+// query timeout by itself, and pgx send a cancel request as soon as the
+// query timeout fires, so that a query that timed out doesn't carry on
+// running on the server. statement_timeout is set once connected rather than
+// as a startup parameter, which PgBouncer rejects.
+func (p *Pinger) enforceQueryTimeout() {
+	if p.Timeouts.Query <= 0 {
+		return
+	}
+	if query := p.statementTimeoutQuery(); query != "" {
+		afterConnect := p.ConnConfig.AfterConnect
+		p.ConnConfig.AfterConnect = func(ctx context.Context, pgConn *pgconn.PgConn) error {
+			if afterConnect != nil {
+				err := afterConnect(ctx, pgConn)
+				if err != nil {
+					return err
+				}
+			}
+			_, err := pgConn.Exec(ctx, query).ReadAll()
+			return err
+		}
+	}
+	p.ConnConfig.BuildContextWatcherHandler = func(pgConn *pgconn.PgConn) ctxwatch.Handler {
+		return &pgconn.CancelRequestContextWatcherHandler{Conn: pgConn, DeadlineDelay: cancelGrace}
+	}
+}
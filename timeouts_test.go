@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDeadlineHit(t *testing.T) {
+	expired := func(flag string) context.Context {
+		ctx, cancel := withTimeout(context.Background(), flag, time.Nanosecond)
+		t.Cleanup(cancel)
+		<-ctx.Done()
+		return ctx
+	}
+	cancelled := &pgconn.PgError{Code: "57014", Message: "canceling statement due to user request"}
+	tests := map[string]struct {
+		ctx          context.Context
+		err          error
+		queryTimeout time.Duration
+		connString   string
+		expect       string
+	}{
+		"no error": {
+			ctx:    expired("query-timeout"),
+			err:    nil,
+			expect: "",
+		},
+		"phase deadline": {
+			ctx:    expired("connect-timeout"),
+			err:    fmt.Errorf("dial error: %w", context.DeadlineExceeded),
+			expect: "connect-timeout",
+		},
+		"ping deadline seen from a phase": {
+			ctx: func() context.Context {
+				ctx, cancel := withTimeout(expired("timeout"), "query-timeout", time.Hour)
+				t.Cleanup(cancel)
+				return ctx
+			}(),
+			err:    context.DeadlineExceeded,
+			expect: "timeout",
+		},
+		"statement timeout": {
+			ctx:          context.Background(),
+			err:          &pgconn.PgError{Code: "57014", Message: "canceling statement due to statement timeout"},
+			queryTimeout: time.Second,
+			expect:       "query-timeout",
+		},
+		"translated statement timeout": {
+			ctx:          context.Background(),
+			err:          &pgconn.PgError{Code: "57014", Message: "Abbruch des Befehls wegen Zeitüberschreitung"},
+			queryTimeout: time.Second,
+			expect:       "query-timeout",
+		},
+		"cancelled without a query timeout": {
+			ctx:    context.Background(),
+			err:    cancelled,
+			expect: "",
+		},
+		"target's own statement timeout": {
+			ctx:          context.Background(),
+			err:          cancelled,
+			queryTimeout: time.Second,
+			connString:   "postgres://db.example.com/app?statement_timeout=100",
+			expect:       "",
+		},
+		"other error": {
+			ctx:    context.Background(),
+			err:    fmt.Errorf("something else"),
+			expect: "",
+		},
+	}
+	for desc, tc := range tests {
+		if tc.connString == "" {
+			tc.connString = "postgres://db.example.com/app"
+		}
+		connConfig, err := pgx.ParseConfig(tc.connString)
+		if err != nil {
+			t.Fatalf("%s: error %v", desc, err)
+		}
+		p := &Pinger{ConnConfig: connConfig, Timeouts: Timeouts{Query: tc.queryTimeout}}
+		assert.Equal(t, tc.expect, p.deadlineHit(tc.ctx, tc.err), desc)
+	}
+}
+
+func TestWithTimeoutZero(t *testing.T) {
+	ctx, cancel := withTimeout(context.Background(), "close-timeout", 0)
+	defer cancel()
+	_, ok := ctx.Deadline()
+	assert.False(t, ok)
+}
+
+func TestEnforceQueryTimeout(t *testing.T) {
+	tests := map[string]struct {
+		connString string
+		timeout    time.Duration
+		expect     string
+	}{
+		"set": {
+			connString: "postgres://db.example.com/app",
+			timeout:    2500 * time.Millisecond,
+			expect:     "SET statement_timeout = 2500",
+		},
+		"under a millisecond": {
+			connString: "postgres://db.example.com/app",
+			timeout:    time.Microsecond,
+			expect:     "SET statement_timeout = 1",
+		},
+		"kept from target": {
+			connString: "postgres://db.example.com/app?statement_timeout=100",
+			timeout:    2 * time.Second,
+			expect:     "",
+		},
+		"no query timeout": {
+			connString: "postgres://db.example.com/app",
+			timeout:    0,
+			expect:     "",
+		},
+	}
+	for desc, tc := range tests {
+		connConfig, err := pgx.ParseConfig(tc.connString)
+		if err != nil {
+			t.Fatalf("%s: error %v", desc, err)
+		}
+		p := &Pinger{ConnConfig: connConfig, Timeouts: Timeouts{Query: tc.timeout}}
+		params := maps.Clone(connConfig.RuntimeParams)
+		assert.Equal(t, tc.expect, p.statementTimeoutQuery(), desc)
+		p.enforceQueryTimeout()
+		assert.Equal(t, tc.expect != "", connConfig.AfterConnect != nil, desc)
+		assert.Equal(t, params, connConfig.RuntimeParams, desc)
+	}
+}
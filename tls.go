@@ -0,0 +1,210 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"math"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// TLSInfo describes the TLS session a ping ran over. Expiry is when the
+// first certificate of the server's chain expires.
+type TLSInfo struct {
+	Version string
+	Cipher  string
+	Subject string
+	Expiry  time.Time
+}
+
+// DaysLeft is how many whole days are left until the server's certificate
+// chain expires.
+func (i *TLSInfo) DaysLeft(now time.Time) int {
+	return int(math.Floor(i.Expiry.Sub(now).Hours() / 24))
+}
+
+// CertThresholds turn a server certificate that is about to expire into WARN
+// or FAIL. Zero disables a threshold.
+type CertThresholds struct {
+	WarnDays int
+	CritDays int
+}
+
+// Status checks the certificate expiry against thresholds as of now.
+func (i *TLSInfo) Status(thresholds CertThresholds, now time.Time) (string, string) {
+	if i.Expiry.IsZero() {
+		return StatusOK, ""
+	}
+	days := i.DaysLeft(now)
+	if thresholds.CritDays > 0 && days < thresholds.CritDays {
+		return StatusFail, fmt.Sprintf("server certificate expires in %d days", days)
+	}
+	if thresholds.WarnDays > 0 && days < thresholds.WarnDays {
+		return StatusWarn, fmt.Sprintf("server certificate expires in %d days", days)
+	}
+	return StatusOK, ""
+}
+
+// tlsInfo returns the details of conn's TLS session, or nil if conn isn't
+// using TLS.
+func tlsInfo(conn net.Conn) *TLSInfo {
+	tlsConn, ok := conn.(*tls.Conn)
+	if !ok {
+		return nil
+	}
+	state := tlsConn.ConnectionState()
+	info := &TLSInfo{
+		Version: tls.VersionName(state.Version),
+		Cipher:  tls.CipherSuiteName(state.CipherSuite),
+	}
+	if len(state.PeerCertificates) > 0 {
+		info.Subject = state.PeerCertificates[0].Subject.String()
+	}
+	for _, cert := range state.PeerCertificates {
+		if info.Expiry.IsZero() || cert.NotAfter.Before(info.Expiry) {
+			info.Expiry = cert.NotAfter
+		}
+	}
+	return info
+}
+
+// readCRL reads a certificate revocation list in PEM or DER form.
+func readCRL(path string) (*x509.RevocationList, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if block, _ := pem.Decode(data); block != nil {
+		data = block.Bytes
+	}
+	crl, err := x509.ParseRevocationList(data)
+	if err != nil {
+		return nil, fmt.Errorf("parsing sslcrl %s: %w", path, err)
+	}
+	return crl, nil
+}
+
+// applyCRL makes every TLS config of connConfig reject server certificates
+// revoked by the CRL in path, which pgx has no support for itself.
+func applyCRL(connConfig *pgx.ConnConfig, path string) error {
+	crl, err := readCRL(path)
+	if err != nil {
+		return err
+	}
+	addVerifyConnection(connConfig, func(state tls.ConnectionState) error {
+		return checkRevoked(crl, state.PeerCertificates)
+	})
+	return nil
+}
+
+// addVerifyConnection adds verify to the checks every TLS config of
+// connConfig runs on the server's certificates during the handshake.
+func addVerifyConnection(connConfig *pgx.ConnConfig, verify func(tls.ConnectionState) error) {
+	configs := []*tls.Config{connConfig.TLSConfig}
+	for _, fallback := range connConfig.Fallbacks {
+		configs = append(configs, fallback.TLSConfig)
+	}
+	for _, config := range configs {
+		if config == nil {
+			continue
+		}
+		previous := config.VerifyConnection
+		config.VerifyConnection = func(state tls.ConnectionState) error {
+			if previous != nil {
+				err := previous(state)
+				if err != nil {
+					return err
+				}
+			}
+			return verify(state)
+		}
+	}
+}
+
+// parsePins parses --tls-pin values, SHA-256 fingerprints of a certificate
+// written as sha256: followed by hex digits, optionally separated by colons.
+func parsePins(values []string) ([][]byte, error) {
+	pins := make([][]byte, 0, len(values))
+	for _, value := range values {
+		digest, ok := strings.CutPrefix(strings.ToLower(value), "sha256:")
+		if !ok {
+			return nil, fmt.Errorf("tls pin `%s` must start with sha256:", value)
+		}
+		pin, err := hex.DecodeString(strings.ReplaceAll(digest, ":", ""))
+		if err != nil || len(pin) != sha256.Size {
+			return nil, fmt.Errorf("tls pin `%s` is not a SHA-256 fingerprint", value)
+		}
+		pins = append(pins, pin)
+	}
+	return pins, nil
+}
+
+// applyPins makes the TLS handshake fail unless the server's leaf or one of
+// its chain certificates has one of the pinned fingerprints. Failing the
+// handshake rather than the ping keeps credentials from being sent to a
+// server that doesn't match. The plain text fallbacks of sslmode=prefer and
+// allow are dropped since they would get around the pin.
+func applyPins(connConfig *pgx.ConnConfig, pins [][]byte) error {
+	candidates := append([]*pgconn.FallbackConfig{{
+		Host:      connConfig.Host,
+		Port:      connConfig.Port,
+		TLSConfig: connConfig.TLSConfig,
+	}}, connConfig.Fallbacks...)
+	encrypted := make([]*pgconn.FallbackConfig, 0, len(candidates))
+	for _, candidate := range candidates {
+		if candidate.TLSConfig != nil {
+			encrypted = append(encrypted, candidate)
+		}
+	}
+	if len(encrypted) == 0 {
+		return fmt.Errorf("--tls-pin needs TLS, but sslmode is `disable`")
+	}
+	connConfig.Host = encrypted[0].Host
+	connConfig.Port = encrypted[0].Port
+	connConfig.TLSConfig = encrypted[0].TLSConfig
+	connConfig.Fallbacks = encrypted[1:]
+	addVerifyConnection(connConfig, func(state tls.ConnectionState) error {
+		return checkPins(pins, state.PeerCertificates)
+	})
+	return nil
+}
+
+func checkPins(pins [][]byte, certs []*x509.Certificate) error {
+	for _, cert := range certs {
+		fingerprint := sha256.Sum256(cert.Raw)
+		for _, pin := range pins {
+			if bytes.Equal(fingerprint[:], pin) {
+				return nil
+			}
+		}
+	}
+	if len(certs) == 0 {
+		return fmt.Errorf("tls error: server sent no certificate to check --tls-pin against")
+	}
+	fingerprint := sha256.Sum256(certs[0].Raw)
+	return fmt.Errorf("tls error: no certificate of %q matches --tls-pin (leaf is sha256:%x)", certs[0].Subject, fingerprint)
+}
+
+func checkRevoked(crl *x509.RevocationList, certs []*x509.Certificate) error {
+	for _, cert := range certs {
+		if !bytes.Equal(cert.RawIssuer, crl.RawIssuer) {
+			continue
+		}
+		for _, revoked := range crl.RevokedCertificateEntries {
+			if revoked.SerialNumber.Cmp(cert.SerialNumber) == 0 {
+				return fmt.Errorf("tls error: certificate %q (serial %s) has been revoked", cert.Subject, cert.SerialNumber)
+			}
+		}
+	}
+	return nil
+}
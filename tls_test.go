@@ -0,0 +1,275 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testCerts struct {
+	CA       *x509.Certificate
+	Server   tls.Certificate
+	CAFile   string
+	CertFile string
+	KeyFile  string
+	CRLFile  string
+}
+
+// writeTestCerts writes a CA, a server certificate for localhost signed by
+// it and a CRL revoking the server certificate into a temporary directory.
+func writeTestCerts(t *testing.T) testCerts {
+	t.Helper()
+	dir := t.TempDir()
+	write := func(name, blockType string, der []byte) string {
+		path := filepath.Join(dir, name)
+		err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0o600)
+		if err != nil {
+			t.Fatalf("error %v", err)
+		}
+		return path
+	}
+
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("error %v", err)
+	}
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "pgping test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatalf("error %v", err)
+	}
+	ca, err := x509.ParseCertificate(caDER)
+	if err != nil {
+		t.Fatalf("error %v", err)
+	}
+
+	serverKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("error %v", err)
+	}
+	serverTemplate := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	serverDER, err := x509.CreateCertificate(rand.Reader, serverTemplate, ca, &serverKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatalf("error %v", err)
+	}
+	serverKeyDER, err := x509.MarshalECPrivateKey(serverKey)
+	if err != nil {
+		t.Fatalf("error %v", err)
+	}
+
+	crlDER, err := x509.CreateRevocationList(rand.Reader, &x509.RevocationList{
+		Number:     big.NewInt(1),
+		ThisUpdate: time.Now().Add(-time.Hour),
+		NextUpdate: time.Now().Add(24 * time.Hour),
+		RevokedCertificateEntries: []x509.RevocationListEntry{
+			{SerialNumber: big.NewInt(2), RevocationTime: time.Now().Add(-time.Minute)},
+		},
+	}, ca, caKey)
+	if err != nil {
+		t.Fatalf("error %v", err)
+	}
+
+	return testCerts{
+		CA: ca,
+		Server: tls.Certificate{
+			Certificate: [][]byte{serverDER, caDER},
+			PrivateKey:  serverKey,
+		},
+		CAFile:   write("ca.pem", "CERTIFICATE", caDER),
+		CertFile: write("server.crt", "CERTIFICATE", serverDER),
+		KeyFile:  write("server.key", "EC PRIVATE KEY", serverKeyDER),
+		CRLFile:  write("ca.crl", "X509 CRL", crlDER),
+	}
+}
+
+func TestTLSInfo(t *testing.T) {
+	certs := writeTestCerts(t)
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{certs.Server}})
+	if err != nil {
+		t.Fatalf("error %v", err)
+	}
+	defer ln.Close()
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		_ = conn.(*tls.Conn).Handshake()
+	}()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(certs.CA)
+	conn, err := tls.Dial("tcp", ln.Addr().String(), &tls.Config{
+		RootCAs:    roots,
+		ServerName: "localhost",
+		MinVersion: tls.VersionTLS13,
+	})
+	if err != nil {
+		t.Fatalf("error %v", err)
+	}
+	defer conn.Close()
+
+	info := tlsInfo(conn)
+	assert.Equal(t, "TLS 1.3", info.Version)
+	assert.NotEmpty(t, info.Cipher)
+	assert.Equal(t, "CN=localhost", info.Subject)
+	assert.Equal(t, certs.CA.NotAfter, info.Expiry)
+
+	assert.Nil(t, tlsInfo(&net.TCPConn{}))
+}
+
+func TestApplyCRL(t *testing.T) {
+	certs := writeTestCerts(t)
+	tg := Target{
+		Host:        "localhost",
+		SSLMode:     "verify-full",
+		SSLRootCert: certs.CAFile,
+		SSLCRL:      certs.CRLFile,
+	}
+	connConfig, err := tg.ToConnConfig()
+	if err != nil {
+		t.Fatalf("error %v", err)
+	}
+	assert.NotContains(t, connConfig.RuntimeParams, "sslcrl")
+
+	leaf, err := x509.ParseCertificate(certs.Server.Certificate[0])
+	if err != nil {
+		t.Fatalf("error %v", err)
+	}
+	err = connConfig.TLSConfig.VerifyConnection(tls.ConnectionState{PeerCertificates: []*x509.Certificate{leaf}})
+	assert.ErrorContains(t, err, "has been revoked")
+	assert.Equal(t, "tls", errorClass(err))
+	err = connConfig.TLSConfig.VerifyConnection(tls.ConnectionState{PeerCertificates: []*x509.Certificate{certs.CA}})
+	assert.NoError(t, err)
+}
+
+func TestCertStatus(t *testing.T) {
+	now := time.Date(2023, 3, 30, 15, 41, 14, 0, time.UTC)
+	thresholds := CertThresholds{WarnDays: 30, CritDays: 7}
+	tests := map[string]struct {
+		expiry         time.Time
+		thresholds     CertThresholds
+		expectedDays   int
+		expectedStatus string
+		expectedMsg    string
+	}{
+		"plenty of time": {
+			expiry:         now.Add(90 * 24 * time.Hour),
+			thresholds:     thresholds,
+			expectedDays:   90,
+			expectedStatus: StatusOK,
+		},
+		"warn": {
+			expiry:         now.Add(29*24*time.Hour + time.Hour),
+			thresholds:     thresholds,
+			expectedDays:   29,
+			expectedStatus: StatusWarn,
+			expectedMsg:    "server certificate expires in 29 days",
+		},
+		"crit": {
+			expiry:         now.Add(3 * 24 * time.Hour),
+			thresholds:     thresholds,
+			expectedDays:   3,
+			expectedStatus: StatusFail,
+			expectedMsg:    "server certificate expires in 3 days",
+		},
+		"expired": {
+			expiry:         now.Add(-time.Hour),
+			thresholds:     thresholds,
+			expectedDays:   -1,
+			expectedStatus: StatusFail,
+			expectedMsg:    "server certificate expires in -1 days",
+		},
+		"no thresholds": {
+			expiry:         now.Add(-time.Hour),
+			expectedDays:   -1,
+			expectedStatus: StatusOK,
+		},
+	}
+	for desc, tc := range tests {
+		info := &TLSInfo{Expiry: tc.expiry}
+		assert.Equal(t, tc.expectedDays, info.DaysLeft(now), desc)
+		status, msg := info.Status(tc.thresholds, now)
+		assert.Equal(t, tc.expectedStatus, status, desc)
+		assert.Equal(t, tc.expectedMsg, msg, desc)
+	}
+}
+
+func TestParsePins(t *testing.T) {
+	digest := "9F86D081884C7D659A2FEAA0C55AD015A3BF4F1B2B0B822CD15D6C15B0F00A08"
+	pins, err := parsePins([]string{"sha256:" + digest, "SHA256:9f:86:d0:81:88:4c:7d:65:9a:2f:ea:a0:c5:5a:d0:15:a3:bf:4f:1b:2b:0b:82:2c:d1:5d:6c:15:b0:f0:0a:08"})
+	if err != nil {
+		t.Fatalf("error %v", err)
+	}
+	assert.Len(t, pins, 2)
+	assert.Equal(t, pins[0], pins[1])
+
+	_, err = parsePins([]string{digest})
+	assert.ErrorContains(t, err, "must start with sha256:")
+	_, err = parsePins([]string{"sha256:abcd"})
+	assert.ErrorContains(t, err, "is not a SHA-256 fingerprint")
+}
+
+func TestApplyPins(t *testing.T) {
+	certs := writeTestCerts(t)
+	leaf, err := x509.ParseCertificate(certs.Server.Certificate[0])
+	if err != nil {
+		t.Fatalf("error %v", err)
+	}
+	caPin := sha256.Sum256(certs.CA.Raw)
+	otherPin := sha256.Sum256([]byte("other"))
+
+	tg := Target{Host: "localhost", SSLMode: "prefer"}
+	connConfig, err := tg.ToConnConfig()
+	if err != nil {
+		t.Fatalf("error %v", err)
+	}
+	err = applyPins(connConfig, [][]byte{otherPin[:], caPin[:]})
+	if err != nil {
+		t.Fatalf("error %v", err)
+	}
+	assert.Empty(t, connConfig.Fallbacks, "plain text fallback should be dropped")
+	verify := connConfig.TLSConfig.VerifyConnection
+	assert.NoError(t, verify(tls.ConnectionState{PeerCertificates: []*x509.Certificate{leaf, certs.CA}}))
+	err = verify(tls.ConnectionState{PeerCertificates: []*x509.Certificate{leaf}})
+	assert.ErrorContains(t, err, "matches --tls-pin")
+	assert.Equal(t, "tls", errorClass(err))
+
+	tg.SSLMode = "disable"
+	connConfig, err = tg.ToConnConfig()
+	if err != nil {
+		t.Fatalf("error %v", err)
+	}
+	assert.Error(t, applyPins(connConfig, [][]byte{caPin[:]}))
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const CheckVisibility = "visibility"
+
+// VisibilityProbe measures how long it takes for a write on a primary to
+// become visible to readers of its replicas, however they replicate. Every
+// tick it writes a heartbeat on the primary, then polls each replica until
+// that heartbeat shows up there.
+type VisibilityProbe struct {
+	// Primary writes the heartbeat; its Check must be CheckWrite.
+	Primary  *Pinger
+	Replicas []*Pinger
+	// Timeout is how long a replica may take to show the heartbeat before
+	// it FAILs.
+	Timeout time.Duration
+	// PollInterval is how long to wait between polls of a replica, which
+	// bounds the precision of the measured delay.
+	PollInterval time.Duration
+}
+
+func NewVisibilityProbe(primary *Pinger, replicas []*Pinger) *VisibilityProbe {
+	primary.Check = CheckWrite
+	for _, replica := range replicas {
+		// replicas only ever poll for the heartbeat
+		replica.Check = CheckPing
+		replica.Heartbeat = primary.Heartbeat
+	}
+	return &VisibilityProbe{
+		Primary:      primary,
+		Replicas:     replicas,
+		Timeout:      *visibilityTimeout,
+		PollInterval: *visibilityPollInterval,
+	}
+}
+
+// Run ticks on the primary's schedule until count is reached or ctx is
+// cancelled and reports whether the last result of the primary and each
+// replica, in that order, failed. The replicas are polled from the moment the
+// heartbeat is committed, while the primary's ping carries on. A tick whose
+// heartbeat isn't committed doesn't poll them, so it only counts against the
+// primary.
+func (v *VisibilityProbe) Run(ctx context.Context) []bool {
+	failed := make([]bool, 1+len(v.Replicas))
+	for i := 1; *count == -1 || i <= *count; i++ {
+		start := time.Now()
+		connectFailures := v.connect(ctx, i)
+		var (
+			polls   sync.WaitGroup
+			results []PingResult
+		)
+		v.Primary.onHeartbeat = func(written heartbeatWrite) {
+			results = make([]PingResult, len(v.Replicas))
+			for j, replica := range v.Replicas {
+				if connectFailures[j] != nil {
+					results[j] = *connectFailures[j]
+					continue
+				}
+				polls.Add(1)
+				go func() {
+					defer polls.Done()
+					results[j] = replica.awaitHeartbeat(ctx, i, written, v.Timeout, v.PollInterval)
+				}()
+			}
+		}
+		res := v.Primary.Ping(ctx, i)
+		polls.Wait()
+		if ctx.Err() != nil {
+			break
+		}
+		v.Primary.observe(res)
+		failed[0] = !res.Pass()
+		for j, replica := range v.Replicas {
+			if results == nil {
+				break
+			}
+			replica.observe(results[j])
+			failed[j+1] = !results[j].Pass()
+		}
+		if i == *count {
+			break
+		}
+		timeUntilNext := v.Primary.Wait - time.Since(start)
+		if timeUntilNext > 0 {
+			select {
+			case <-ctx.Done():
+			case <-time.After(timeUntilNext):
+			}
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+
+	v.Primary.closeHeld()
+	for _, replica := range v.Replicas {
+		replica.closeHeld()
+	}
+	return failed
+}
+
+// connect makes sure every replica holds a connection before the heartbeat is
+// written, so that connecting doesn't count towards the delay. It returns
+// the results of the connection attempts that failed by replica.
+func (v *VisibilityProbe) connect(ctx context.Context, i int) []*PingResult {
+	failures := make([]*PingResult, len(v.Replicas))
+	var wg sync.WaitGroup
+	for j, replica := range v.Replicas {
+		if replica.conn != nil && !replica.conn.IsClosed() {
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			connectCtx, cancel := withTimeout(ctx, "timeout", replica.Timeout)
+			defer cancel()
+			res := replica.connect(connectCtx, i)
+			if !res.Pass() {
+				failures[j] = &res
+			}
+		}()
+	}
+	wg.Wait()
+	return failures
+}
+
+// awaitHeartbeat polls until the written heartbeat is visible on this
+// pinger's target. The connection is held open across ticks whether or not
+// the pinger is persistent, since polling over fresh connections would
+// measure connection setup rather than replication. It is normally already
+// open; connecting here is a last resort that counts towards the delay.
+func (p *Pinger) awaitHeartbeat(parent context.Context, i int, written heartbeatWrite, timeout, interval time.Duration) PingResult {
+	ctx, cancel := context.WithDeadline(parent, written.Committed.Add(timeout))
+	defer cancel()
+	if p.conn == nil || p.conn.IsClosed() {
+		res := p.connect(ctx, i)
+		if !res.Pass() {
+			return res
+		}
+	}
+	timings := NewTimings()
+	res := PingResult{Server: p.connServer, TLS: p.connTLS}
+	for {
+		visible, err := p.Heartbeat.visible(withPhase(ctx, timings, PhaseRead), p.conn, written)
+		if visible {
+			res.Status = StatusOK
+			res.Visibility = time.Since(written.Committed)
+			return p.result(i, written.Committed, timings, res)
+		}
+		if err != nil && ctx.Err() == nil {
+			res.Status, res.Msg, res.Err = StatusErr, "error polling heartbeat", err
+			return p.result(i, written.Committed, timings, res)
+		}
+		select {
+		case <-ctx.Done():
+		case <-time.After(interval):
+		}
+		if ctx.Err() != nil {
+			res.Status = StatusFail
+			res.Msg = fmt.Sprintf("heartbeat i=%d not visible after %s", written.Iteration, timeout)
+			return p.result(i, written.Committed, timings, res)
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVisibilityProbeRun(t *testing.T) {
+	primary := newFakeServer(t)
+	lagging := newFakeServer(t)
+	lagging.primary, lagging.lag = primary, 50*time.Millisecond
+	// never sees the primary's heartbeat
+	detached := newFakeServer(t)
+	results := captureResults(t)
+	defer func(c int) { *count = c }(*count)
+	*count = 2
+
+	pinger := func(server *fakeServer, label string) *Pinger {
+		return &Pinger{
+			Label:      label,
+			ConnConfig: server.connConfig(t),
+			Timeout:    5 * time.Second,
+			Wait:       10 * time.Millisecond,
+			Queries:    []Query{{SQL: "SELECT 1"}},
+			Heartbeat:  Heartbeat{Table: "pgping_heartbeat", Client: "test"},
+			Stats:      NewStats(),
+		}
+	}
+	replicas := []*Pinger{pinger(lagging, "lagging"), pinger(detached, "detached")}
+	v := NewVisibilityProbe(pinger(primary, "primary"), replicas)
+	v.Timeout = 200 * time.Millisecond
+	v.PollInterval = 5 * time.Millisecond
+
+	failed := v.Run(context.Background())
+	assert.Equal(t, []bool{false, false, true}, failed)
+	assert.Equal(t, 1, replicas[0].connects, "replicas reconnected between ticks")
+
+	byTarget := map[string][]map[string]any{}
+	for _, line := range results() {
+		if line["event"] == nil {
+			byTarget[line["target"].(string)] = append(byTarget[line["target"].(string)], line)
+		}
+	}
+	if assert.Len(t, byTarget["primary"], 2) {
+		for _, line := range byTarget["primary"] {
+			assert.Equal(t, StatusOK, line["status"])
+		}
+	}
+	if assert.Len(t, byTarget["lagging"], 2) {
+		for _, line := range byTarget["lagging"] {
+			assert.Equal(t, StatusOK, line["status"])
+			assert.GreaterOrEqual(t, line["visibility_ns"], float64(50*time.Millisecond))
+		}
+	}
+	if assert.Len(t, byTarget["detached"], 2) {
+		assert.Equal(t, StatusFail, byTarget["detached"][0]["status"])
+		assert.Equal(t, "heartbeat i=1 not visible after 200ms", byTarget["detached"][0]["msg"])
+	}
+}
+
+func TestAwaitHeartbeat(t *testing.T) {
+	primary := newFakeServer(t)
+	replica := newFakeServer(t)
+	replica.primary = primary
+	captureResults(t)
+	heartbeat := Heartbeat{Table: "pgping_heartbeat", Client: "test"}
+	writer := &Pinger{ConnConfig: primary.connConfig(t), Timeout: 5 * time.Second, Heartbeat: heartbeat}
+	defer writer.closeHeld()
+	res := writer.connect(context.Background(), 1)
+	if !assert.Equal(t, StatusOK, res.Status) {
+		return
+	}
+	written, failure, err := heartbeat.write(context.Background(), writer.conn, NewTimings(), 7, nil)
+	if err != nil {
+		t.Fatalf("error %v", err)
+	}
+	assert.Empty(t, failure)
+
+	p := &Pinger{ConnConfig: replica.connConfig(t), Timeout: 5 * time.Second, Heartbeat: heartbeat}
+	defer p.closeHeld()
+	res = p.awaitHeartbeat(context.Background(), 7, written, time.Second, 5*time.Millisecond)
+	assert.Equal(t, StatusOK, res.Status)
+	assert.Positive(t, res.Visibility)
+	assert.Equal(t, 1, p.connects, "no connection was made to poll over")
+
+	stale := written
+	stale.Iteration++
+	stale.Committed = time.Now()
+	res = p.awaitHeartbeat(context.Background(), 8, stale, 20*time.Millisecond, 5*time.Millisecond)
+	assert.Equal(t, StatusFail, res.Status)
+	assert.Equal(t, "heartbeat i=8 not visible after 20ms", res.Msg)
+}